@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	URL "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxWarmURLs     = 100 // Per POST /admin/cache/warm
+	warmConcurrency = 4
+)
+
+var adminToken string // Bearer token required on /admin routes, empty disables them
+
+// loadAdminConfig reads the admin API token from the environment.
+func loadAdminConfig() {
+	adminToken = os.Getenv("LINK2JSON_ADMIN_TOKEN")
+}
+
+// adminAuth rejects requests that don't carry the admin token. Without a
+// configured token the admin routes are disabled altogether.
+func adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminToken == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Admin API is disabled"})
+			return
+		}
+		token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// cacheEntryInfo describes one cached extraction for GET /admin/cache.
+type cacheEntryInfo struct {
+	Options    string      `json:"options,omitempty"` // The profile and language the variant was extracted with
+	FetchedAt  time.Time   `json:"fetched_at"`
+	Age        float64     `json:"age_seconds"`
+	TTL        float64     `json:"ttl_seconds"` // Time left before the entry goes stale, negative once it has
+	Stale      bool        `json:"stale"`
+	ExpiresAt  time.Time   `json:"expires_at"` // When it is dropped altogether
+	Error      string      `json:"error,omitempty"`
+	Extraction *extraction `json:"extraction,omitempty"`
+}
+
+// adminCacheHandler lists every cached variant of a URL.
+func adminCacheHandler(c *gin.Context) {
+	raw, ok := queryURL(c)
+	if !ok {
+		return
+	}
+	url, _ := normalizeURL(raw)
+
+	now := time.Now()
+	entries := []cacheEntryInfo{}
+	for key, item := range extractionCache.Items() {
+		if cacheKeyURL(key) != url {
+			continue
+		}
+		entry := item.Object.(*cacheEntry)
+		info := cacheEntryInfo{
+			Options:    cacheKeyOptions(key),
+			FetchedAt:  entry.FetchedAt,
+			Age:        now.Sub(entry.FetchedAt).Seconds(),
+			TTL:        entry.FreshUntil.Sub(now).Seconds(),
+			Stale:      now.After(entry.FreshUntil),
+			ExpiresAt:  time.Unix(0, item.Expiration),
+			Extraction: entry.Result,
+		}
+		if entry.Err != nil {
+			info.Error = entry.Err.Error()
+		}
+		entries = append(entries, info)
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not cached", "url": url})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"url": url, "entries": entries})
+}
+
+// adminPurgeHandler drops the cached entries for a URL, or for every URL on a
+// domain and its subdomains, together with the final URL aliases they were
+// stored under and their cached article and JSON-LD.
+func adminPurgeHandler(c *gin.Context) {
+	var match func(url string) bool
+	if domain := strings.ToLower(strings.TrimSuffix(c.Query("domain"), ".")); domain != "" {
+		match = func(url string) bool {
+			u, err := URL.Parse(url)
+			if err != nil {
+				return false
+			}
+			host := u.Hostname()
+			return host == domain || strings.HasSuffix(host, "."+domain)
+		}
+	} else {
+		raw, ok := queryURL(c)
+		if !ok {
+			return
+		}
+		url, _ := normalizeURL(raw)
+		match = func(u string) bool { return u == url }
+	}
+
+	purged := 0
+	for key, item := range extractionCache.Items() {
+		if !match(cacheKeyURL(key)) {
+			continue
+		}
+		keys := []string{key}
+		if result := item.Object.(*cacheEntry).Result; result != nil {
+			keys = append(keys, aliasKey(key, result.FinalURL))
+		}
+		for _, k := range keys {
+			if _, found := extractionCache.Get(k); found {
+				extractionCache.Delete(k)
+				purged++
+			}
+			detailsCache.Delete(k)
+		}
+	}
+	for key := range detailsCache.Items() {
+		if match(cacheKeyURL(key)) {
+			detailsCache.Delete(key)
+		}
+	}
+	cachePurges.Add(int64(purged))
+	logrus.Info("Purged ", purged, " cache entries")
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+// aliasKey returns the cache key url is stored under with the same options
+// as key.
+func aliasKey(key, url string) string {
+	return extractOptions{}.cacheKey(url) + strings.TrimPrefix(key, cacheKeyURL(key))
+}
+
+// adminWarmHandler extracts a list of URLs into the cache ahead of time.
+func adminWarmHandler(c *gin.Context) {
+	var req struct {
+		URLs []string `json:"urls"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.URLs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A JSON body with a urls list is required"})
+		return
+	}
+	if len(req.URLs) > maxWarmURLs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many URLs, the limit is " + strconv.Itoa(maxWarmURLs)})
+		return
+	}
+
+	type warmResult struct {
+		URL    string `json:"url"`
+		Status string `json:"status"` // X-Cache status, or "error"
+		Error  string `json:"error,omitempty"`
+	}
+	results := make([]warmResult, len(req.URLs))
+	sem := make(chan struct{}, warmConcurrency)
+	var wg sync.WaitGroup
+	for i, raw := range req.URLs {
+		results[i].URL = raw
+		if _, err := validateURL(raw); err != nil {
+			results[i].Status, results[i].Error = "error", "Invalid URL"
+			continue
+		}
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			_, status, err := cachedMetadata(c.Request.Context(), url, extractOptions{})
+			results[i].Status = status
+			if err != nil {
+				results[i].Status, results[i].Error = "error", err.Error()
+			}
+		}(i, raw)
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// adminStatsHandler reports the cache size and how well it is doing.
+func adminStatsHandler(c *gin.Context) {
+	hits, stale, misses := cacheHits.Value(), cacheStaleHits.Value(), cacheMisses.Value()
+	ratio := 0.0
+	if total := hits + stale + misses; total > 0 {
+		ratio = float64(hits+stale) / float64(total)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"size":       extractionCache.ItemCount(),
+		"hits":       hits,
+		"stale_hits": stale,
+		"misses":     misses,
+		"hit_ratio":  ratio,
+		"evictions":  cacheEvictions.Value() - cachePurges.Value(),
+		"purges":     cachePurges.Value(),
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	URL "net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminPurgeRemovesAliases(t *testing.T) {
+	setupExtraction(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			fmt.Fprint(w, `<html><head><title>New</title><meta property="og:site_name" content="Site"></head><body><p>Long enough paragraph of text, with commas, to count.</p></body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	old := upstream.URL + "/old"
+	if _, _, err := cachedMetadata(context.Background(), old, extractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cachedDetails(context.Background(), old, upstream.URL+"/new", extractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if extractionCache.ItemCount() != 2 || detailsCache.ItemCount() != 1 {
+		t.Fatalf("cached %d extractions and %d details, want 2 and 1", extractionCache.ItemCount(), detailsCache.ItemCount())
+	}
+
+	entries := adminRequest(t, adminCacheHandler, http.MethodGet, old)
+	var listing struct {
+		Entries []struct {
+			Extraction map[string]any `json:"extraction"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(entries.Body.Bytes(), &listing); err != nil || len(listing.Entries) != 1 {
+		t.Fatalf("listing: %s", entries.Body)
+	}
+	if got := listing.Entries[0].Extraction["final_url"]; got != upstream.URL+"/new" {
+		t.Errorf("extraction final_url = %v, keys should be snake_case: %s", got, entries.Body)
+	}
+
+	purge := adminRequest(t, adminPurgeHandler, http.MethodDelete, old)
+	if purge.Code != http.StatusOK || purge.Body.String() != `{"purged":2}` {
+		t.Errorf("purge answered %d %s", purge.Code, purge.Body)
+	}
+	if extractionCache.ItemCount() != 0 || detailsCache.ItemCount() != 0 {
+		t.Errorf("left %d extractions and %d details behind", extractionCache.ItemCount(), detailsCache.ItemCount())
+	}
+}
+
+func adminRequest(t *testing.T, handler gin.HandlerFunc, method, url string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/admin/cache?url="+URL.QueryEscape(url), nil)
+	handler(c)
+	return w
+}
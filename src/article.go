@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/net/html"
+)
+
+const (
+	minParagraphLength = 25  // Shorter blocks are captions, bylines and buttons
+	wordsPerMinute     = 200 // For the reading time estimate
+)
+
+// detailsCache holds pageDetails, kept apart from extractionCache because
+// they are only worked out when a client asks for them.
+var detailsCache *cache.Cache
+
+// article is the main text of a page.
+type article struct {
+	Title       string `json:"title,omitempty"`
+	Byline      string `json:"byline,omitempty"`
+	Text        string `json:"text"`
+	WordCount   int    `json:"word_count"`
+	ReadingTime int    `json:"reading_time"` // Minutes
+}
+
+// pageDetails are the parts of a page too expensive to extract on every
+// request: they need the page parsed again, so they are fetched on demand.
+type pageDetails struct {
+	Article *article         `json:"article,omitempty"`
+	JSONLD  []map[string]any `json:"jsonld,omitempty"`
+}
+
+// cachedDetails returns the article and JSON-LD of url, fetching the page
+// again on a cache miss. That is finalURL, where the extraction of url ended
+// up after redirects and any hreflang alternate, so the details come from
+// the same page as the metadata. Concurrent misses share a fetch.
+func cachedDetails(ctx context.Context, url, finalURL string, opts extractOptions) (*pageDetails, error) {
+	key := opts.cacheKey(url)
+	opts.FollowHreflang = false // Already followed to get finalURL
+	if cached, found := detailsCache.Get(key); found {
+		return cached.(*pageDetails), nil
+	}
+
+	res, _, err := extractions.Do(ctx, "details\x00"+key, func(ctx context.Context) (any, error) {
+		ctx, cancel := withUpstreamTimeout(ctx)
+		defer cancel()
+		doc, _, err := fetchDocument(ctx, finalURL, opts)
+		if errors.Is(err, errNotHTML) {
+			// Files have neither, and parsing them as HTML would make some up
+			details := &pageDetails{}
+			detailsCache.SetDefault(key, details)
+			return details, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// JSON-LD lives in script tags extractArticle throws away, so goes first
+		details := &pageDetails{JSONLD: parseJSONLD(doc)}
+		details.Article = extractArticle(doc)
+		detailsCache.SetDefault(key, details)
+		return details, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*pageDetails), nil
+}
+
+// extractArticle finds the main text of doc, readability style: paragraphs
+// score points for their length and commas, their parent and grandparent
+// collect them, and the best scoring element after discounting links wins.
+// It modifies doc. Pages without any real paragraphs have no article.
+func extractArticle(doc *goquery.Document) *article {
+	title, _ := firstMatch(doc, titleRules)
+	result := &article{
+		Title:  cleanText(title),
+		Byline: cleanText(doc.Find(`meta[name="author"]`).AttrOr("content", "")),
+	}
+	// The headline over the text beats the og:title written for sharing
+	if h1 := cleanText(doc.Find("article h1, main h1, h1").First().Text()); h1 != "" {
+		result.Title = h1
+	}
+	if result.Byline == "" {
+		result.Byline = cleanText(doc.Find(`[rel="author"], [itemprop="author"], .byline, .author`).First().Text())
+	}
+
+	doc.Find(`script, style, noscript, template, nav, header, footer, aside, form, iframe, svg, [role="navigation"], [aria-hidden="true"]`).Remove()
+
+	scores := map[*html.Node]float64{}
+	doc.Find("p, pre, blockquote").Each(func(_ int, p *goquery.Selection) {
+		text := cleanText(p.Text())
+		if len(text) < minParagraphLength {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text))/100, 3)
+		if parent := p.Parent(); parent.Length() > 0 {
+			scores[parent.Get(0)] += score
+			if grandparent := parent.Parent(); grandparent.Length() > 0 {
+				scores[grandparent.Get(0)] += score / 2
+			}
+		}
+	})
+
+	var best *goquery.Selection
+	bestScore := 0.0
+	for node, score := range scores {
+		candidate := doc.FindNodes(node)
+		text := candidate.Text()
+		if len(text) == 0 {
+			continue
+		}
+		links := len(candidate.Find("a").Text())
+		score *= 1 - float64(links)/float64(len(text))
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	var blocks []string
+	best.Find("h2, h3, h4, p, pre, blockquote, li").Each(func(_ int, s *goquery.Selection) {
+		// Nested blocks are covered by their outermost one
+		if s.ParentsUntilSelection(best).Filter("p, pre, blockquote, li").Length() > 0 {
+			return
+		}
+		if text := cleanText(s.Text()); text != "" {
+			blocks = append(blocks, text)
+		}
+	})
+	result.Text = strings.Join(blocks, "\n\n")
+	result.WordCount = len(strings.Fields(result.Text))
+	result.ReadingTime = int(math.Ceil(float64(result.WordCount) / wordsPerMinute))
+	return result
+}
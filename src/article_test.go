@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDetailsOfNonHTML(t *testing.T) {
+	setupExtraction(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(`<p>Not markup, however much this line of plain text, with commas, looks like it.</p>`))
+	}))
+	defer upstream.Close()
+
+	details, err := cachedDetails(context.Background(), upstream.URL+"/notes.txt", upstream.URL+"/notes.txt", extractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Article != nil || len(details.JSONLD) != 0 {
+		t.Errorf("plain text parsed as HTML: %+v", details)
+	}
+}
+
+func TestDetailsFollowHreflang(t *testing.T) {
+	setupExtraction(t)
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		text := map[string]string{
+			"/en": "An English paragraph that is long enough, with commas, to be the article.",
+			"/de": "Ein deutscher Absatz, lang genug, mit Kommas, um der Artikel zu sein.",
+		}[r.URL.Path]
+		if text == "" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `<html><head><title>%s</title><link rel="alternate" hreflang="de" href="%s/de"><link rel="alternate" hreflang="en" href="%s/en"></head><body><article><p>%s</p></article></body></html>`,
+			r.URL.Path[1:], upstream.URL, upstream.URL, text)
+	}))
+	defer upstream.Close()
+
+	url := upstream.URL + "/en"
+	opts := extractOptions{AcceptLanguage: "de", FollowHreflang: true}
+	result, _, err := cachedMetadata(context.Background(), url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Metadata.Title != "de" {
+		t.Fatalf("metadata title %q, want the German page's", result.Metadata.Title)
+	}
+	details, err := cachedDetails(context.Background(), url, result.FinalURL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Article == nil || !strings.HasPrefix(details.Article.Text, "Ein deutscher") {
+		t.Errorf("article %+v, want the German page's", details.Article)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+)
+
+// How a cachedMetadata result was obtained, reported in the X-Cache header.
+const (
+	cacheHit    = "HIT"
+	cacheStale  = "STALE" // Past its TTL but within the grace window, a refresh is running
+	cacheMiss   = "MISS"
+	cacheShared = "SHARED" // Joined a fetch another request had already started
+)
+
+var (
+	extractionCache *cache.Cache
+	extractions     fetchGroup
+
+	cacheTTL         time.Duration // How long a successful extraction is fresh
+	cacheGrace       time.Duration // How long after that it may still be served while refreshing
+	negativeCacheTTL time.Duration // How long a failed extraction is remembered
+)
+
+// fetchGroup coalesces concurrent fetches of the same key, like
+// singleflight.Group, except that the shared fetch can still be cancelled:
+// it runs under its own context, which is cancelled once every caller
+// waiting on it has given up.
+type fetchGroup struct {
+	mu    sync.Mutex
+	calls map[string]*fetchCall
+}
+
+// fetchCall is a running fetch and the callers waiting on it.
+type fetchCall struct {
+	done    chan struct{}
+	val     any
+	err     error
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// Do runs fn for key unless a call for the key is already running, and waits
+// for its result or for ctx to be done. shared reports whether the call was
+// started by another caller.
+func (g *fetchGroup) Do(ctx context.Context, key string, fn func(context.Context) (any, error)) (v any, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*fetchCall{}
+	}
+	call, shared := g.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &fetchCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			call.val, call.err = fn(callCtx)
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		g.mu.Lock()
+		call.waiters--
+		g.mu.Unlock()
+		return call.val, shared, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody wants the result any more, later callers start afresh
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return nil, shared, ctx.Err()
+	}
+}
+
+// cacheEntry is a cached extraction, or the error a failed one returned.
+type cacheEntry struct {
+	Result     *extraction
+	Err        error
+	FetchedAt  time.Time
+	FreshUntil time.Time
+
+	refreshAfter atomic.Int64 // Unix nanoseconds, a stale entry isn't refreshed again before
+}
+
+// loadCacheConfig reads the cache lifetimes from the environment.
+func loadCacheConfig() {
+	cacheTTL = envDuration("LINK2JSON_CACHE_TTL", time.Hour)
+	cacheGrace = envDuration("LINK2JSON_CACHE_GRACE", 10*time.Minute)
+	negativeCacheTTL = envDuration("LINK2JSON_NEGATIVE_CACHE_TTL", time.Minute)
+	extractionCache = cache.New(cacheTTL+cacheGrace, 10*time.Minute)
+	extractionCache.OnEvicted(func(string, any) { cacheEvictions.Add(1) })
+	detailsCache = cache.New(cacheTTL, 10*time.Minute)
+}
+
+// cachedMetadata returns the extraction for url, serving it from
+// the cache when possible. Entries past their TTL are still served during the
+// grace window while a background refresh runs, and failures are cached for
+// a shorter time so dead links don't hammer upstream. Concurrent misses for
+// the same key share a single upstream fetch.
+func cachedMetadata(ctx context.Context, url string, opts extractOptions) (*extraction, string, error) {
+	key := opts.cacheKey(url)
+	if cached, found := extractionCache.Get(key); found {
+		entry := cached.(*cacheEntry)
+		if time.Now().Before(entry.FreshUntil) {
+			cacheHits.Add(1)
+			return entry.Result, cacheHit, entry.Err
+		}
+		if entry.Err == nil {
+			cacheStaleHits.Add(1)
+			// One refresh at a time, and a failed one isn't retried before the
+			// negative cache TTL, so a dead upstream isn't hit on every request
+			now := time.Now()
+			if next := entry.refreshAfter.Load(); now.UnixNano() >= next && entry.refreshAfter.CompareAndSwap(next, now.Add(negativeCacheTTL).UnixNano()) {
+				go refreshMetadata(key, url, opts)
+			}
+			return entry.Result, cacheStale, nil
+		}
+	}
+	cacheMisses.Add(1)
+
+	// The fetch carries on while any caller still waits for it, and is
+	// cancelled once the last one gives up
+	res, shared, err := extractions.Do(ctx, key, func(ctx context.Context) (any, error) {
+		return fetchAndStore(ctx, key, url, opts)
+	})
+	status := cacheMiss
+	if shared {
+		status = cacheShared
+	}
+	if err != nil {
+		return nil, status, err
+	}
+	return res.(*extraction), status, nil
+}
+
+// refreshMetadata re-extracts a stale entry in the background. Success
+// replaces the entry, failure leaves it to be served until the grace window
+// runs out.
+func refreshMetadata(key, url string, opts extractOptions) {
+	_, _, err := extractions.Do(context.Background(), key, func(ctx context.Context) (any, error) {
+		return fetchAndStore(ctx, key, url, opts)
+	})
+	if err != nil {
+		logrus.Warn("Failed to refresh ", url, ", serving stale metadata: ", err)
+	}
+}
+
+// fetchAndStore extracts url and caches the outcome under key. Successful
+// results are also stored under the final URL so other links to the same
+// page hit the cache. The canonical URL is only reported, pages can claim
+// any. A failed refresh leaves a usable stale entry in place rather than
+// replacing it with the error.
+func fetchAndStore(ctx context.Context, key, url string, opts extractOptions) (*extraction, error) {
+	if cached, found := extractionCache.Get(key); found {
+		if entry := cached.(*cacheEntry); time.Now().Before(entry.FreshUntil) {
+			return entry.Result, entry.Err
+		}
+	}
+	return storeExtraction(ctx, key, url, opts)
+}
+
+// forceRefresh re-extracts url even if its cache entry is still fresh, for
+// callers on their own schedule such as watches.
+func forceRefresh(ctx context.Context, url string, opts extractOptions) (*extraction, error) {
+	key := opts.cacheKey(url)
+	res, _, err := extractions.Do(ctx, key, func(ctx context.Context) (any, error) {
+		return storeExtraction(ctx, key, url, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*extraction), nil
+}
+
+// storeExtraction does the upstream half of fetchAndStore.
+func storeExtraction(ctx context.Context, key, url string, opts extractOptions) (*extraction, error) {
+	// Revalidate what we have rather than download the page again
+	var previous *cacheEntry
+	if cached, found := extractionCache.Get(key); found && cached.(*cacheEntry).Err == nil {
+		previous = cached.(*cacheEntry)
+		if v := previous.Result.Validators; v.ETag != "" || v.LastModified != "" {
+			opts.revalidate = &v
+			upstreamRevalidations.Add(1)
+		}
+	}
+
+	ctx, cancel := withUpstreamTimeout(ctx)
+	defer cancel()
+	now := time.Now()
+	result, err := getMetadata(ctx, url, opts)
+	if errors.Is(err, errNotModified) && previous != nil {
+		upstreamNotModified.Add(1)
+		logrus.Debug("Upstream not modified, extending cache entry for ", url)
+		entry := &cacheEntry{Result: previous.Result, FetchedAt: previous.FetchedAt, FreshUntil: now.Add(cacheTTL)}
+		extractionCache.Set(key, entry, cacheTTL+cacheGrace)
+		return previous.Result, nil
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		if previous != nil {
+			return nil, err
+		}
+		extractionCache.Set(key, &cacheEntry{Err: err, FetchedAt: now, FreshUntil: now.Add(negativeCacheTTL)}, negativeCacheTTL)
+		return nil, err
+	}
+	result.doc = nil // Only needed while rules run, don't keep parsed pages around
+	pendingSnapshots.Add(1)
+	go func() {
+		defer pendingSnapshots.Done()
+		recordSnapshot(key, result)
+	}()
+
+	entry := &cacheEntry{Result: result, FetchedAt: now, FreshUntil: now.Add(cacheTTL)}
+	extractionCache.Set(key, entry, cacheTTL+cacheGrace)
+	if finalKey := opts.cacheKey(result.FinalURL); finalKey != key {
+		// Requested directly, the final URL has no redirects of its own
+		direct := *result
+		direct.Redirects = []redirectHop{}
+		extractionCache.Set(finalKey, &cacheEntry{Result: &direct, FetchedAt: now, FreshUntil: entry.FreshUntil}, cacheTTL+cacheGrace)
+	}
+	return result, nil
+}
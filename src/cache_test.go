@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchGroupCancelsAbandonedFetch(t *testing.T) {
+	var g fetchGroup
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	fn := func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return nil, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, _, err := g.Do(ctx1, "k", fn); errs <- err }()
+	<-started
+	go func() { _, _, err := g.Do(ctx2, "k", fn); errs <- err }()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller got %v, want context.Canceled", err)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("fetch cancelled while a caller was still waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel2()
+	<-errs
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("fetch still running after every caller gave up")
+	}
+}
+
+func TestFetchGroupSharesResult(t *testing.T) {
+	var g fetchGroup
+	release := make(chan struct{})
+	calls := 0
+	fn := func(context.Context) (any, error) {
+		calls++
+		<-release
+		return "result", nil
+	}
+
+	type outcome struct {
+		v      any
+		shared bool
+	}
+	results := make(chan outcome, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			v, shared, _ := g.Do(context.Background(), "k", fn)
+			results <- outcome{v, shared}
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+
+	sharedCount := 0
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.v != "result" {
+			t.Errorf("got %v, want result", r.v)
+		}
+		if r.shared {
+			sharedCount++
+		}
+	}
+	if calls != 1 || sharedCount != 1 {
+		t.Errorf("calls = %d, shared = %d, want 1 and 1", calls, sharedCount)
+	}
+}
+
+func TestStaleRefreshBacksOff(t *testing.T) {
+	setupExtraction(t)
+	var mu sync.Mutex
+	hits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		hits++
+		n := hits
+		mu.Unlock()
+		if n > 1 {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `<html><head><title>Page</title><meta property="og:site_name" content="Site"></head></html>`)
+	}))
+	defer upstream.Close()
+
+	url := upstream.URL + "/page"
+	if _, status, err := cachedMetadata(context.Background(), url, extractOptions{}); err != nil || status != cacheMiss {
+		t.Fatalf("first request: %s, %v", status, err)
+	}
+	cached, _ := extractionCache.Get(extractOptions{}.cacheKey(url))
+	cached.(*cacheEntry).FreshUntil = time.Now().Add(-time.Second)
+
+	for i := 0; i < 5; i++ {
+		result, status, err := cachedMetadata(context.Background(), url, extractOptions{})
+		if err != nil || status != cacheStale || result.Metadata.Title != "Page" {
+			t.Fatalf("stale request %d: %s, %v", i, status, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 2 {
+		t.Errorf("upstream hit %d times, want the first fetch and one refresh", hits)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+)
+
+const (
+	sourceHeader = "header"
+
+	charsetSniffBytes = 8192 // More than the 1024 bytes the meta prescan needs, to give chardet enough text
+)
+
+var (
+	metaCharsetRe = regexp.MustCompile(`(?i)<meta[^>]+charset`)
+	boms          = [][]byte{{0xEF, 0xBB, 0xBF}, {0xFE, 0xFF}, {0xFF, 0xFE}}
+)
+
+// decodeHTML wraps body in a reader that converts it to UTF-8. The encoding
+// is taken from the byte order mark, the Content-Type charset or a
+// <meta charset> declaration, in that order, falling back to statistical
+// detection when the page declares nothing.
+func decodeHTML(body io.Reader, contentType string) (io.Reader, string, fieldSource) {
+	buffered := bufio.NewReaderSize(body, charsetSniffBytes)
+	peek, _ := buffered.Peek(charsetSniffBytes)
+
+	enc, name, certain := charset.DetermineEncoding(peek, contentType)
+	var src fieldSource
+	switch {
+	case certain && hasBOM(peek):
+		src = fieldSource{Source: sourceFallback, Selector: "byte order mark", Confidence: 1}
+	case certain:
+		src = fieldSource{Source: sourceHeader, Selector: "Content-Type charset", Confidence: 0.95}
+	case metaCharsetRe.Match(peek[:min(len(peek), 1024)]):
+		src = fieldSource{Source: sourceMeta, Selector: "meta[charset]", Confidence: 0.9}
+	case name == "utf-8":
+		src = fieldSource{Source: sourceFallback, Selector: "utf-8 validation", Confidence: 0.8}
+	default:
+		src = fieldSource{Source: sourceFallback, Selector: "windows-1252 default", Confidence: 0.3}
+		if detected, err := chardet.NewHtmlDetector().DetectBest(peek); err == nil {
+			if e, n := charset.Lookup(detected.Charset); e != nil {
+				enc, name = e, n
+				src = fieldSource{Source: sourceFallback, Selector: "statistical detection", Confidence: float64(detected.Confidence) / 100}
+			}
+		}
+	}
+
+	if name == "utf-8" {
+		// Nothing to convert, invalid sequences are dealt with by cleanText
+		return buffered, name, src
+	}
+	return transform.NewReader(buffered, enc.NewDecoder()), name, src
+}
+
+func hasBOM(b []byte) bool {
+	for _, bom := range boms {
+		if bytes.HasPrefix(b, bom) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanText makes extracted text safe to display: valid UTF-8, entities
+// left over from double escaping decoded and whitespace collapsed.
+func cleanText(s string) string {
+	s = strings.ToValidUTF8(s, "�")
+	if strings.Contains(s, "&") {
+		s = html.UnescapeString(s)
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// envDuration reads a duration such as "90s" or "1h" from key, using fallback when unset or invalid.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logrus.Warn("Invalid duration for ", key, ", using default: ", fallback)
+		return fallback
+	}
+	return d
+}
+
+// envInt reads an integer from key, using fallback when unset or invalid.
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		logrus.Warn("Invalid number for ", key, ", using default: ", fallback)
+		return fallback
+	}
+	return n
+}
+
+// envBool reads a boolean from key, using fallback when unset or invalid.
+func envBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		logrus.Warn("Invalid boolean for ", key, ", using default: ", fallback)
+		return fallback
+	}
+	return b
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	_ "image/gif" // Register decoders for image.DecodeConfig
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	URL "net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	link2json "github.com/BumpyClock/go-link2json"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sniffBytes = 3072 // Enough for mimetype to recognise every format it supports
+)
+
+// fileInfo describes a non-HTML resource.
+type fileInfo struct {
+	Size     int64    `json:"size,omitempty"` // Bytes
+	Width    int      `json:"width,omitempty"`
+	Height   int      `json:"height,omitempty"`
+	Author   string   `json:"author,omitempty"`
+	Pages    int      `json:"pages,omitempty"`
+	Duration float64  `json:"duration_seconds,omitempty"`
+	Codecs   []string `json:"codecs,omitempty"`
+}
+
+// sniffContentType returns the media type of resp and a reader over its
+// complete body. The Content-Type header is trusted unless it is missing or
+// generic, in which case the first bytes of the body decide.
+func sniffContentType(resp *upstreamResponse) (string, map[string]string, io.Reader) {
+	body := bufio.NewReaderSize(resp.Body, sniffBytes)
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err == nil && mediaType != "application/octet-stream" && mediaType != "binary/octet-stream" {
+		return mediaType, params, body
+	}
+
+	peek, _ := body.Peek(sniffBytes)
+	detected, params, err := mime.ParseMediaType(mimetype.Detect(peek).String())
+	if err != nil {
+		return "application/octet-stream", nil, body
+	}
+	return detected, params, body
+}
+
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// extractFile builds metadata for a resource that isn't an HTML page.
+func extractFile(url string, resp *upstreamResponse, mediaType string, params map[string]string, body io.Reader) (*extraction, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	truncated := bodyTruncated(resp.Body)
+
+	file := &fileInfo{Size: resp.ContentLength}
+	if file.Size <= 0 && !truncated {
+		file.Size = int64(len(data))
+	}
+
+	result := &link2json.MetaDataResponseItem{URL: url, Images: []link2json.WebImage{}}
+	result.Domain = getBaseDomain(resp.FinalURL)
+	sources := map[string]fieldSource{}
+
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		img := link2json.WebImage{URL: resp.FinalURL, Type: mediaType}
+		if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			img.Width, img.Height = config.Width, config.Height
+			file.Width, file.Height = config.Width, config.Height
+		}
+		result.Images = append(result.Images, img)
+		sources["images"] = fieldSource{Source: sourceFallback, Selector: "resource url", Confidence: 1}
+	case mediaType == "application/pdf":
+		result.Title = pdfInfoString(data, "Title")
+		setSource(sources, "title", result.Title, fieldSource{Source: sourceMeta, Selector: "pdf /Title", Confidence: 0.8})
+		result.Description = pdfInfoString(data, "Subject")
+		setSource(sources, "description", result.Description, fieldSource{Source: sourceMeta, Selector: "pdf /Subject", Confidence: 0.8})
+		file.Author = pdfInfoString(data, "Author")
+		file.Pages = pdfPageCount(data)
+	case strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/"):
+		file.Duration, file.Codecs = mp4Info(data)
+		if codecs := params["codecs"]; codecs != "" && len(file.Codecs) == 0 {
+			for _, codec := range strings.Split(codecs, ",") {
+				file.Codecs = append(file.Codecs, strings.TrimSpace(codec))
+			}
+		}
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var doc map[string]any
+		if json.Unmarshal(data, &doc) == nil {
+			result.Title, _ = jsonLDString([]map[string]any{doc}, "title", "name")
+			setSource(sources, "title", result.Title, fieldSource{Source: sourceMeta, Selector: "json title", Confidence: 0.6})
+			result.Description, _ = jsonLDString([]map[string]any{doc}, "description", "summary")
+			setSource(sources, "description", result.Description, fieldSource{Source: sourceMeta, Selector: "json description", Confidence: 0.6})
+		}
+	}
+
+	result.Title = cleanText(result.Title)
+	result.Description = cleanText(result.Description)
+	file.Author = cleanText(file.Author)
+	if result.Title == "" {
+		result.Title = fileName(resp.FinalURL)
+		setSource(sources, "title", result.Title, fieldSource{Source: sourceFallback, Selector: "file name", Confidence: 0.3})
+	}
+	if parsed, err := URL.Parse(resp.FinalURL); err == nil {
+		result.Sitename = parsed.Hostname()
+		setSource(sources, "sitename", result.Sitename, fieldSource{Source: sourceFallback, Selector: "hostname", Confidence: 0.2})
+	}
+	if len(result.Images) == 0 {
+		result.Images = append(result.Images, link2json.WebImage{})
+	}
+
+	canonical, err := normalizeURL(resp.FinalURL)
+	if err != nil {
+		canonical = url
+	}
+
+	logrus.Debug("[getMetadata] Inspected ", mediaType, " ", url)
+	return &extraction{
+		Metadata:     result,
+		CanonicalURL: canonical,
+		FinalURL:     resp.FinalURL,
+		Status:       resp.StatusCode,
+		Redirects:    resp.Redirects,
+		ContentType:  mediaType,
+		File:         file,
+		Sources:      sources,
+	}, nil
+}
+
+// fileName returns the unescaped last path segment of rawURL.
+func fileName(rawURL string) string {
+	parsed, err := URL.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(parsed.Path)
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+var (
+	pdfPageRe = regexp.MustCompile(`/Type\s*/Page[^s]`)
+	pdfOctal  = regexp.MustCompile(`^[0-7]{1,3}`)
+
+	// pdfInfoRes matches the information dictionary entries we read, with
+	// their literal or hex string value as the first group.
+	pdfInfoRes = map[string]*regexp.Regexp{}
+)
+
+func init() {
+	for _, key := range []string{"Title", "Subject", "Author"} {
+		pdfInfoRes[key] = regexp.MustCompile(`(?s)/` + key + `\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+	}
+}
+
+// pdfInfoString reads an entry of the PDF document information dictionary.
+// Only uncompressed dictionaries are visible, which covers most PDFs that
+// bother to set one. key has to be one of pdfInfoRes.
+func pdfInfoString(data []byte, key string) string {
+	match := pdfInfoRes[key].FindSubmatch(data)
+	if match == nil {
+		return ""
+	}
+
+	var raw []byte
+	token := match[1]
+	if token[0] == '<' {
+		hex := strings.Join(strings.Fields(string(token[1:len(token)-1])), "")
+		for i := 0; i+1 < len(hex); i += 2 {
+			b, _ := strconv.ParseUint(hex[i:i+2], 16, 8)
+			raw = append(raw, byte(b))
+		}
+	} else {
+		raw = pdfUnescape(token[1 : len(token)-1])
+	}
+
+	// Text strings are UTF-16BE with a byte order mark or PDFDocEncoding,
+	// which matches Latin-1 for printable characters
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, binary.BigEndian.Uint16(raw[i:]))
+		}
+		return strings.TrimSpace(string(utf16.Decode(units)))
+	}
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+	return strings.TrimSpace(string(runes))
+}
+
+func pdfUnescape(s []byte) []byte {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '\n', '\r':
+			// Line continuation
+		default:
+			if octal := pdfOctal.Find(s[i:]); octal != nil {
+				b, _ := strconv.ParseUint(string(octal), 8, 8)
+				out = append(out, byte(b))
+				i += len(octal) - 1
+			} else {
+				out = append(out, s[i])
+			}
+		}
+	}
+	return out
+}
+
+func pdfPageCount(data []byte) int {
+	return len(pdfPageRe.FindAllIndex(data, -1))
+}
+
+// mp4Info reads the duration and sample entry codecs from an ISO base media
+// file (MP4, M4A, MOV). The moov box has to be within the inspected bytes.
+func mp4Info(data []byte) (float64, []string) {
+	var duration float64
+	var codecs []string
+
+	var walk func(data []byte)
+	walk = func(data []byte) {
+		for len(data) >= 8 {
+			size := uint64(binary.BigEndian.Uint32(data))
+			kind := string(data[4:8])
+			header := uint64(8)
+			switch size {
+			case 0:
+				size = uint64(len(data))
+			case 1:
+				if len(data) < 16 {
+					return
+				}
+				size, header = binary.BigEndian.Uint64(data[8:]), 16
+			}
+			if size < header || size > uint64(len(data)) {
+				return
+			}
+			box := data[header:size]
+
+			switch kind {
+			case "moov", "trak", "mdia", "minf", "stbl":
+				walk(box)
+			case "mvhd":
+				duration = mvhdDuration(box)
+			case "stsd":
+				if len(box) >= 16 {
+					codecs = append(codecs, strings.TrimSpace(string(box[12:16])))
+				}
+			}
+			data = data[size:]
+		}
+	}
+	walk(data)
+	return duration, codecs
+}
+
+func mvhdDuration(box []byte) float64 {
+	if len(box) < 1 {
+		return 0
+	}
+	var timescale, units uint64
+	if box[0] == 1 {
+		if len(box) < 32 {
+			return 0
+		}
+		timescale, units = uint64(binary.BigEndian.Uint32(box[20:])), binary.BigEndian.Uint64(box[24:])
+	} else {
+		if len(box) < 20 {
+			return 0
+		}
+		timescale, units = uint64(binary.BigEndian.Uint32(box[12:])), uint64(binary.BigEndian.Uint32(box[16:]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return float64(units) / float64(timescale)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestPDFInfoString(t *testing.T) {
+	tests := []struct {
+		name string
+		pdf  string
+		want string
+	}{
+		{"literal", `<< /Title (Annual Report) >>`, "Annual Report"},
+		{"escapes", `<< /Title (Q\(1\)\tResults\n) >>`, "Q(1)\tResults"},
+		{"octal escape", `<< /Title (Caf\351 \101) >>`, "Café A"},
+		{"line continuation", "<< /Title (Long \\\ntitle) >>", "Long title"},
+		{"hex", `<< /Title <416E6E75616C 20 5265706F7274> >>`, "Annual Report"},
+		{"hex with odd digits", `<< /Title <4142434> >>`, "ABC"},
+		{"utf-16 hex", `<< /Title <FEFF 0052 00E9 0073 0075 006D 00E9> >>`, "Résumé"},
+		{"utf-16 literal", "<< /Title (\xFE\xFF\x00H\x00i) >>", "Hi"},
+		{"utf-16 odd length", "<< /Title (\xFE\xFF\x00H\x00) >>", "H"},
+		{"bom only", "<< /Title <FEFF> >>", ""},
+		{"empty", `<< /Title () >>`, ""},
+		{"empty hex", `<< /Title <> >>`, ""},
+		{"unterminated literal", `<< /Title (Annual Report`, ""},
+		{"unterminated hex", `<< /Title <416E6E75616C`, ""},
+		{"trailing backslash", `<< /Title (Annual\`, ""},
+		{"not a string", `<< /Title 12 0 R >>`, ""},
+		{"missing", `<< /Author (Someone) >>`, ""},
+		{"no data", ``, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pdfInfoString([]byte(tt.pdf), "Title"); got != tt.want {
+				t.Errorf("pdfInfoString(%q) = %q, want %q", tt.pdf, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFPageCount(t *testing.T) {
+	pdf := []byte("1 0 obj << /Type /Pages /Kids [2 0 R 3 0 R] /Count 2 >> endobj\n" +
+		"2 0 obj << /Type /Page /Parent 1 0 R >> endobj\n" +
+		"3 0 obj <</Type/Page/Parent 1 0 R>> endobj\n")
+	if got := pdfPageCount(pdf); got != 2 {
+		t.Errorf("pdfPageCount = %d, want 2", got)
+	}
+	if got := pdfPageCount(nil); got != 0 {
+		t.Errorf("pdfPageCount(nil) = %d, want 0", got)
+	}
+
+	// Cut-off documents lose pages but never fail
+	doc := []byte("<< /Title (Report) /Author <FEFF00410042> >>\n" + string(pdf))
+	for i := range doc {
+		pdfInfoString(doc[:i], "Title")
+		pdfInfoString(doc[:i], "Author")
+		if got := pdfPageCount(doc[:i]); got > 2 {
+			t.Fatalf("pdfPageCount of %d bytes = %d", i, got)
+		}
+	}
+}
+
+func TestMP4Info(t *testing.T) {
+	mvhd0 := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd0[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd0[16:], 12500)
+	mvhd1 := make([]byte, 32)
+	mvhd1[0] = 1
+	binary.BigEndian.PutUint32(mvhd1[20:], 90000)
+	binary.BigEndian.PutUint64(mvhd1[24:], 90000*7200)
+	stsd := func(codec string) []byte {
+		return append(make([]byte, 12), codec...)
+	}
+	track := func(codec string) []byte {
+		return mp4Box("trak", mp4Box("mdia", mp4Box("minf", mp4Box("stbl", mp4Box("stsd", stsd(codec))))))
+	}
+	movie := join(mp4Box("ftyp", []byte("isom")), mp4Box("moov", join(mp4Box("mvhd", mvhd0), track("avc1"), track("mp4a"))))
+
+	largesize := make([]byte, 16)
+	binary.BigEndian.PutUint32(largesize, 1)
+	copy(largesize[4:], "moov")
+	binary.BigEndian.PutUint64(largesize[8:], 16+uint64(len(mp4Box("mvhd", mvhd0))))
+	largesize = append(largesize, mp4Box("mvhd", mvhd0)...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		duration float64
+		codecs   []string
+	}{
+		{"movie", movie, 12.5, []string{"avc1", "mp4a"}},
+		{"version 1 header", mp4Box("moov", mp4Box("mvhd", mvhd1)), 7200, nil},
+		{"64-bit box size", largesize, 12.5, nil},
+		{"box to end of file", append([]byte{0, 0, 0, 0, 'm', 'o', 'o', 'v'}, mp4Box("mvhd", mvhd0)...), 12.5, nil},
+		{"moov past the inspected bytes", append(mp4Box("ftyp", []byte("isom")), movie[:len(movie)-10]...), 0, nil},
+		{"size smaller than header", []byte{0, 0, 0, 4, 'm', 'o', 'o', 'v', 0, 0, 0, 0}, 0, nil},
+		{"truncated 64-bit size", []byte{0, 0, 0, 1, 'm', 'o', 'o', 'v', 0, 0}, 0, nil},
+		{"64-bit size smaller than header", join([]byte{0, 0, 0, 1, 'm', 'o', 'o', 'v'}, make([]byte, 7), []byte{8}), 0, nil},
+		{"short version 0 header", mp4Box("moov", mp4Box("mvhd", mvhd0[:19])), 0, nil},
+		{"short version 1 header", mp4Box("moov", mp4Box("mvhd", mvhd1[:31])), 0, nil},
+		{"empty header", mp4Box("moov", mp4Box("mvhd", nil)), 0, nil},
+		{"zero timescale", mp4Box("moov", mp4Box("mvhd", make([]byte, 20))), 0, nil},
+		{"short sample description", mp4Box("moov", mp4Box("trak", mp4Box("mdia", mp4Box("minf", mp4Box("stbl", mp4Box("stsd", make([]byte, 15))))))), 0, nil},
+		{"not a box", []byte("GIF89a"), 0, nil},
+		{"empty", nil, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duration, codecs := mp4Info(tt.data)
+			if duration != tt.duration || !reflect.DeepEqual(codecs, tt.codecs) {
+				t.Errorf("mp4Info = %v, %q, want %v, %q", duration, codecs, tt.duration, tt.codecs)
+			}
+		})
+	}
+
+	for i := range movie {
+		mp4Info(movie[:i])
+	}
+}
+
+// mp4Box wraps payload in an ISO base media box of the given kind.
+func mp4Box(kind string, payload []byte) []byte {
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(box, kind...), payload...)
+}
+
+func join(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// fieldChange is one field that differs between two snapshots. Before or
+// After is nil when the field was added or removed.
+type fieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// snapshotRef identifies one side of a diff.
+type snapshotRef struct {
+	ID        int64     `json:"id"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// diffHandler compares the two most recent snapshots of a URL field by field.
+func diffHandler(c *gin.Context) {
+	if historyDB == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "History is disabled"})
+		return
+	}
+	raw, ok := queryURL(c)
+	if !ok {
+		return
+	}
+	url, _ := normalizeURL(raw)
+	opts, err := extractOptionsFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Only snapshots of the same variant compare, another language isn't a change
+	options := cacheKeyOptions(opts.cacheKey(url))
+
+	snapshots, err := latestSnapshots(c.Request.Context(), url, options, 2)
+	if err != nil {
+		logrus.Error("Failed to query history: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query history"})
+		return
+	}
+	if len(snapshots) < 2 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "At least two snapshots are needed for a diff", "snapshots": len(snapshots)})
+		return
+	}
+
+	changes, err := diffSnapshots(snapshots[1].Metadata, snapshots[0].Metadata)
+	if err != nil {
+		logrus.Error("Failed to diff snapshots of ", url, ": ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to diff snapshots"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"url":     url,
+		"from":    snapshotRef{ID: snapshots[1].ID, FetchedAt: snapshots[1].FetchedAt},
+		"to":      snapshotRef{ID: snapshots[0].ID, FetchedAt: snapshots[0].FetchedAt},
+		"changes": changes,
+	})
+}
+
+// latestSnapshots returns up to n snapshots of url extracted with options,
+// newest first.
+func latestSnapshots(ctx context.Context, url, options string, n int) ([]snapshot, error) {
+	rows, err := historyDB.QueryContext(ctx,
+		"SELECT id, url, options, canonical_url, fetched_at, status, metadata FROM snapshots WHERE url = ? AND options = ? ORDER BY fetched_at DESC, id DESC LIMIT ?",
+		url, options, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []snapshot
+	for rows.Next() {
+		s, err := scanSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+// scanSnapshot reads a snapshots row selected in column order.
+func scanSnapshot(rows *sql.Rows) (snapshot, error) {
+	var s snapshot
+	var fetchedAt int64
+	var metadata string
+	if err := rows.Scan(&s.ID, &s.URL, &s.Options, &s.CanonicalURL, &fetchedAt, &s.Status, &metadata); err != nil {
+		return s, err
+	}
+	s.FetchedAt = time.UnixMilli(fetchedAt).UTC()
+	s.Metadata = json.RawMessage(metadata)
+	return s, nil
+}
+
+// diffSnapshots lists the fields that differ between two stored payloads,
+// sorted by field path. Timings change on every fetch and are ignored.
+func diffSnapshots(before, after json.RawMessage) ([]fieldChange, error) {
+	var a, b any
+	if err := json.Unmarshal(before, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &b); err != nil {
+		return nil, err
+	}
+	old, updated := map[string]any{}, map[string]any{}
+	flattenJSON("", a, old)
+	flattenJSON("", b, updated)
+
+	changes := []fieldChange{}
+	for field, value := range old {
+		if other, ok := updated[field]; !ok || !reflect.DeepEqual(value, other) {
+			changes = append(changes, fieldChange{Field: field, Before: value, After: other})
+		}
+	}
+	for field, value := range updated {
+		if _, ok := old[field]; !ok {
+			changes = append(changes, fieldChange{Field: field, After: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// flattenJSON records the leaves of v under dotted paths such as
+// images.0.url, so a change deep inside a list is reported on its own.
+func flattenJSON(prefix string, v any, out map[string]any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if key == "duration" {
+				continue
+			}
+			flattenJSON(joinPath(prefix, key), value, out)
+		}
+	case []any:
+		if len(v) == 0 {
+			out[prefix] = v
+		}
+		for i, value := range v {
+			flattenJSON(joinPath(prefix, strconv.Itoa(i)), value, out)
+		}
+	default:
+		out[prefix] = v
+	}
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
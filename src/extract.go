@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	URL "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	link2json "github.com/BumpyClock/go-link2json"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of places a metadata field can be taken from.
+const (
+	sourceTag      = "tag"
+	sourceMeta     = "meta"
+	sourceJSONLD   = "jsonld"
+	sourceFallback = "fallback"
+)
+
+// fieldSource records where a metadata field was taken from and how much we trust it.
+type fieldSource struct {
+	Source     string  `json:"source"`
+	Selector   string  `json:"selector,omitempty"`
+	Confidence float64 `json:"confidence"`
+}
+
+// extraction is the metadata of a page together with the provenance of each field.
+type extraction struct {
+	Metadata     *link2json.MetaDataResponseItem `json:"metadata"`
+	CanonicalURL string                          `json:"canonical_url"`
+	FinalURL     string                          `json:"final_url"`
+	Status       int                             `json:"status"` // Upstream HTTP status of the final response
+	Redirects    []redirectHop                   `json:"redirects"`
+	ContentType  string                          `json:"content_type"`
+	FetchedAt    time.Time                       `json:"fetched_at"`
+	Validators   upstreamValidators              `json:"validators"`
+	Charset      string                          `json:"charset,omitempty"`
+	Language     string                          `json:"language,omitempty"`
+	File         *fileInfo                       `json:"file,omitempty"` // Only set for non-HTML resources
+	Sources      map[string]fieldSource          `json:"sources"`
+	RuleEffects  []ruleEffect                    `json:"rule_effects,omitempty"`
+
+	doc *goquery.Document
+}
+
+// extractOptions are the per-request settings that change what we fetch.
+type extractOptions struct {
+	AcceptLanguage string // Sent upstream as the Accept-Language header
+	FollowHreflang bool   // Switch to the hreflang alternate best matching AcceptLanguage
+	Profile        string // User agent profile, empty defers to the rules file and default
+
+	revalidate *upstreamValidators // Set when refreshing a cached entry, not part of the cache key
+}
+
+// upstreamValidators are the cache validators an upstream sent with a page.
+type upstreamValidators struct {
+	URL          string `json:"url,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// cacheKey identifies the extraction of url under these options. The
+// normalized URL comes first, separated from the options by NUL, see
+// cacheKeyURL.
+func (o extractOptions) cacheKey(url string) string {
+	key, err := normalizeURL(url)
+	if err != nil {
+		key = url
+	}
+	if o.Profile != "" {
+		key += "\x00ua=" + o.Profile
+	}
+	if lang := normalizeAcceptLanguage(o.AcceptLanguage); lang != "" {
+		key += "\x00lang=" + lang
+		if o.FollowHreflang {
+			key += "\x00hreflang"
+		}
+	}
+	return key
+}
+
+// cacheKeyURL returns the URL part of a cache key.
+func cacheKeyURL(key string) string {
+	url, _, _ := strings.Cut(key, "\x00")
+	return url
+}
+
+// cacheKeyOptions returns the options part of a cache key, space separated,
+// such as "ua=browser lang=de".
+func cacheKeyOptions(key string) string {
+	_, options, _ := strings.Cut(key, "\x00")
+	return strings.ReplaceAll(options, "\x00", " ")
+}
+
+// selectorRule reads a field from the first element matching selector.
+type selectorRule struct {
+	selector   string
+	attr       string // Empty reads the element text
+	source     string
+	confidence float64
+}
+
+// Rules are tried in order, the first non-empty value wins. Each chain starts
+// with the tag link2json.GetMetadata reads, but the fallbacks after it mean
+// /extract no longer matches the library field for field:
+//
+//   - title falls back to og:title, twitter:title, the JSON-LD headline and
+//     the first h1; description to og:description, twitter:description and
+//     JSON-LD, where the library left them empty
+//   - sitename falls back to application-name, the JSON-LD publisher and the
+//     hostname, and a homepage that fails to load no longer fails the request
+//   - favicon falls back to /favicon.ico and is resolved against the final
+//     URL rather than prefixed with the domain
+//   - images fall back to twitter:image, image_src and the JSON-LD image
+//
+// Results are cached by cachedMetadata, not the library's unbounded cache.
+var (
+	titleRules = []selectorRule{
+		{"title", "", sourceTag, 0.8},
+		{`meta[property="og:title"]`, "content", sourceMeta, 0.9},
+		{`meta[name="twitter:title"]`, "content", sourceMeta, 0.85},
+	}
+	descriptionRules = []selectorRule{
+		{`meta[name="description"]`, "content", sourceMeta, 0.85},
+		{`meta[property="og:description"]`, "content", sourceMeta, 0.9},
+		{`meta[name="twitter:description"]`, "content", sourceMeta, 0.8},
+	}
+	sitenameRules = []selectorRule{
+		{`meta[property="og:site_name"]`, "content", sourceMeta, 0.9},
+		{`meta[name="application-name"]`, "content", sourceMeta, 0.7},
+	}
+	faviconRules = []selectorRule{
+		{`link[rel="icon"]`, "href", sourceTag, 0.9},
+		{`link[rel="shortcut icon"]`, "href", sourceTag, 0.9},
+		{`link[rel="apple-touch-icon"]`, "href", sourceTag, 0.8},
+		{`link[rel="apple-touch-icon-precomposed"]`, "href", sourceTag, 0.8},
+	}
+	canonicalRules = []selectorRule{
+		{`link[rel="canonical"]`, "href", sourceTag, 0.95},
+		{`meta[property="og:url"]`, "content", sourceMeta, 0.85},
+	}
+	imageRules = []selectorRule{
+		{`meta[property="og:image"]`, "content", sourceMeta, 0.9},
+		{`meta[name="twitter:image"]`, "content", sourceMeta, 0.85},
+		{`link[rel="image_src"]`, "href", sourceTag, 0.7},
+	}
+)
+
+// getMetadata fetches url and extracts its metadata, producing the fields of
+// link2json.GetMetadata for HTML pages with the fallbacks listed above, and
+// describing any other kind of resource by its content type.
+func getMetadata(ctx context.Context, url string, opts extractOptions) (*extraction, error) {
+	fetchedAt := time.Now()
+	resp, err := fetch(ctx, url, opts)
+	if err != nil {
+		logrus.Error("[getMetadata] Failed to visit URL: ", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && opts.revalidate != nil {
+		return nil, errNotModified
+	}
+	if resp.StatusCode >= http.StatusBadRequest || resp.StatusCode == http.StatusNotModified {
+		return nil, fmt.Errorf("upstream returned %s", resp.Status)
+	}
+	validators := upstreamValidators{
+		URL:          resp.FinalURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	mediaType, params, body := sniffContentType(resp)
+	if !isHTML(mediaType) {
+		result, err := extractFile(url, resp, mediaType, params, body)
+		if err == nil {
+			result.FetchedAt, result.Validators = fetchedAt, validators
+		}
+		if err == nil && result.Language == "" {
+			result.Language = normalizeLanguageTag(resp.Header.Get("Content-Language"))
+			setSource(result.Sources, "language", result.Language, fieldSource{Source: sourceHeader, Selector: "Content-Language", Confidence: 0.85})
+		}
+		if err == nil {
+			result.RuleEffects = applyRules(result)
+		}
+		return result, err
+	}
+
+	body, charsetName, charsetSrc := decodeHTML(body, resp.Header.Get("Content-Type"))
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, err
+	}
+	// Free the host's fetch slot before any follow-up fetches to the same host
+	resp.Body.Close()
+	// Relative links on the page resolve against where we ended up
+	base := resp.FinalURL
+
+	if opts.FollowHreflang && opts.AcceptLanguage != "" {
+		if alternate := hreflangAlternate(doc, base, opts.AcceptLanguage); alternate != "" && alternate != base {
+			logrus.Debug("[getMetadata] Following hreflang alternate ", alternate)
+			opts.FollowHreflang = false
+			result, err := getMetadata(ctx, alternate, opts)
+			if err != nil {
+				return nil, err
+			}
+			result.Metadata.URL = url
+			result.Redirects = append(resp.Redirects, result.Redirects...)
+			return result, nil
+		}
+	}
+
+	result := &link2json.MetaDataResponseItem{URL: url, Images: []link2json.WebImage{}}
+	result.Domain = getBaseDomain(base)
+	sources := map[string]fieldSource{"charset": charsetSrc}
+	jsonLD := parseJSONLD(doc)
+
+	var src fieldSource
+	if result.Title, src = firstMatch(doc, titleRules); result.Title == "" {
+		result.Title, src = jsonLDString(jsonLD, "headline", "name")
+	}
+	if result.Title == "" {
+		result.Title, src = firstMatch(doc, []selectorRule{{"h1", "", sourceFallback, 0.5}})
+	}
+	result.Title = cleanText(result.Title)
+	setSource(sources, "title", result.Title, src)
+
+	if result.Description, src = firstMatch(doc, descriptionRules); result.Description == "" {
+		result.Description, src = jsonLDString(jsonLD, "description")
+	}
+	result.Description = cleanText(result.Description)
+	setSource(sources, "description", result.Description, src)
+
+	if result.Sitename, src = firstMatch(doc, sitenameRules); result.Sitename == "" {
+		result.Sitename, src = jsonLDPublisher(jsonLD)
+	}
+	if result.Sitename == "" {
+		result.Sitename, src = homepageTitle(ctx, result.Domain, opts)
+	}
+	if result.Sitename == "" {
+		if parsed, err := URL.Parse(base); err == nil {
+			result.Sitename, src = parsed.Hostname(), fieldSource{Source: sourceFallback, Selector: "hostname", Confidence: 0.2}
+		}
+	}
+	result.Sitename = cleanText(result.Sitename)
+	setSource(sources, "sitename", result.Sitename, src)
+
+	if result.Favicon, src = firstMatch(doc, faviconRules); result.Favicon == "" && result.Domain != "" {
+		result.Favicon, src = result.Domain+"/favicon.ico", fieldSource{Source: sourceFallback, Selector: "/favicon.ico", Confidence: 0.3}
+	}
+	result.Favicon = resolveReference(base, result.Favicon)
+	setSource(sources, "favicon", result.Favicon, src)
+
+	image := link2json.WebImage{}
+	if image.URL, src = firstMatch(doc, imageRules); image.URL == "" {
+		image.URL, src = jsonLDImage(jsonLD)
+	}
+	image.URL = resolveReference(base, image.URL)
+	image.Alt, _ = firstMatch(doc, []selectorRule{{`meta[property="og:image:alt"]`, "content", sourceMeta, 0.9}})
+	image.Alt = cleanText(image.Alt)
+	image.Type, _ = firstMatch(doc, []selectorRule{{`meta[property="og:image:type"]`, "content", sourceMeta, 0.9}})
+	width, _ := firstMatch(doc, []selectorRule{{`meta[property="og:image:width"]`, "content", sourceMeta, 0.9}})
+	image.Width, _ = strconv.Atoi(width)
+	height, _ := firstMatch(doc, []selectorRule{{`meta[property="og:image:height"]`, "content", sourceMeta, 0.9}})
+	image.Height, _ = strconv.Atoi(height)
+	result.Images = append(result.Images, image)
+	setSource(sources, "images", image.URL, src)
+
+	canonical, src := firstMatch(doc, canonicalRules)
+	if canonical != "" {
+		canonical, err = normalizeURL(resolveReference(base, canonical))
+	}
+	if canonical == "" || err != nil {
+		canonical, err = normalizeURL(base)
+		src = fieldSource{Source: sourceFallback, Selector: "final url", Confidence: 0.5}
+	}
+	if err != nil {
+		canonical = url
+	}
+	setSource(sources, "canonical_url", canonical, src)
+
+	lang, src := detectLanguage(doc, resp.Header.Get("Content-Language"))
+	setSource(sources, "language", lang, src)
+
+	logrus.Debug("[getMetadata] Scraping finished ", url)
+	ext := &extraction{
+		Metadata:     result,
+		CanonicalURL: canonical,
+		FinalURL:     resp.FinalURL,
+		Status:       resp.StatusCode,
+		Redirects:    resp.Redirects,
+		ContentType:  mediaType,
+		FetchedAt:    fetchedAt,
+		Validators:   validators,
+		Charset:      charsetName,
+		Language:     lang,
+		Sources:      sources,
+		doc:          doc,
+	}
+	ext.RuleEffects = applyRules(ext)
+	return ext, nil
+}
+
+// firstMatch returns the first non-empty value produced by rules.
+func firstMatch(doc *goquery.Document, rules []selectorRule) (string, fieldSource) {
+	for _, rule := range rules {
+		sel := doc.Find(rule.selector).First()
+		if sel.Length() == 0 {
+			continue
+		}
+		var value string
+		if rule.attr == "" {
+			value = sel.Text()
+		} else {
+			value = sel.AttrOr(rule.attr, "")
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			return value, fieldSource{Source: rule.source, Selector: rule.selector, Confidence: rule.confidence}
+		}
+	}
+	return "", fieldSource{}
+}
+
+// setSource records src for field when a value was found.
+func setSource(sources map[string]fieldSource, field, value string, src fieldSource) {
+	if value != "" {
+		sources[field] = src
+	}
+}
+
+// homepageTitle falls back to the og:title of the site's homepage, which is
+// what link2json.GetMetadata uses when a page has no og:site_name.
+func homepageTitle(ctx context.Context, domain string, opts extractOptions) (string, fieldSource) {
+	if domain == "" {
+		return "", fieldSource{}
+	}
+	doc, _, err := fetchDocument(ctx, domain, opts)
+	if err != nil {
+		logrus.Debug("[getMetadata] Failed to visit base domain: ", err)
+		return "", fieldSource{}
+	}
+	title, src := firstMatch(doc, []selectorRule{{`meta[property="og:title"]`, "content", sourceFallback, 0.4}})
+	if title != "" {
+		src.Selector = "homepage " + src.Selector
+	}
+	return title, src
+}
+
+// parseJSONLD collects every JSON-LD object on the page, flattening arrays and @graph lists.
+func parseJSONLD(doc *goquery.Document) []map[string]any {
+	var objects []map[string]any
+	var collect func(v any)
+	collect = func(v any) {
+		switch v := v.(type) {
+		case []any:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]any:
+			objects = append(objects, v)
+			if graph, ok := v["@graph"]; ok {
+				collect(graph)
+			}
+		}
+	}
+
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, s *goquery.Selection) {
+		var v any
+		if err := json.Unmarshal([]byte(s.Text()), &v); err != nil {
+			logrus.Debug("[getMetadata] Skipping invalid JSON-LD: ", err)
+			return
+		}
+		collect(v)
+	})
+	return objects
+}
+
+// jsonLDString returns the first string value found under any of keys.
+func jsonLDString(objects []map[string]any, keys ...string) (string, fieldSource) {
+	for _, key := range keys {
+		for _, obj := range objects {
+			if value, ok := obj[key].(string); ok && strings.TrimSpace(value) != "" {
+				return strings.TrimSpace(value), fieldSource{Source: sourceJSONLD, Selector: key, Confidence: 0.85}
+			}
+		}
+	}
+	return "", fieldSource{}
+}
+
+func jsonLDPublisher(objects []map[string]any) (string, fieldSource) {
+	for _, obj := range objects {
+		if publisher, ok := obj["publisher"].(map[string]any); ok {
+			if name, ok := publisher["name"].(string); ok && name != "" {
+				return name, fieldSource{Source: sourceJSONLD, Selector: "publisher.name", Confidence: 0.8}
+			}
+		}
+	}
+	return "", fieldSource{}
+}
+
+// jsonLDImage understands the string, ImageObject and list forms of the image property.
+func jsonLDImage(objects []map[string]any) (string, fieldSource) {
+	var imageURL func(v any) string
+	imageURL = func(v any) string {
+		switch v := v.(type) {
+		case string:
+			return v
+		case map[string]any:
+			url, _ := v["url"].(string)
+			return url
+		case []any:
+			for _, item := range v {
+				if url := imageURL(item); url != "" {
+					return url
+				}
+			}
+		}
+		return ""
+	}
+
+	for _, obj := range objects {
+		if url := imageURL(obj["image"]); url != "" {
+			return url, fieldSource{Source: sourceJSONLD, Selector: "image", Confidence: 0.8}
+		}
+	}
+	return "", fieldSource{}
+}
+
+// resolveReference makes ref absolute relative to base, leaving it untouched on error.
+func resolveReference(base, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	baseURL, err := URL.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refURL, err := URL.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return baseURL.ResolveReference(refURL).String()
+}
+
+func getBaseDomain(url string) string {
+	parsedURL, err := URL.Parse(url)
+	if err != nil {
+		return ""
+	}
+
+	return parsedURL.Scheme + "://" + parsedURL.Host
+}
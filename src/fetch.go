@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// Set up from upstreamConfig on start
+	httpClient   *http.Client
+	maxRedirects int
+	maxBodyBytes int64
+	totalTimeout time.Duration
+
+	errTooManyRedirects = errors.New("too many redirects")
+	errNotModified      = errors.New("upstream not modified")
+	errNotHTML          = errors.New("upstream is not an HTML page")
+)
+
+// redirectHop is one redirect response on the way to the final URL.
+type redirectHop struct {
+	URL      string `json:"url"`
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+	Duration int    `json:"duration"` // Milliseconds
+}
+
+// upstreamResponse is the final response for a URL and the redirects that led to it.
+type upstreamResponse struct {
+	*http.Response
+	FinalURL  string
+	Redirects []redirectHop
+}
+
+// fetch GETs rawURL, following up to maxRedirects redirects. Fetches are
+// paced per host, and a 429 response is retried once after its Retry-After.
+// At most maxBodyBytes of the body can be read. The caller must close the
+// body to free the host's fetch slot.
+func fetch(ctx context.Context, rawURL string, opts extractOptions) (*upstreamResponse, error) {
+	result := &upstreamResponse{FinalURL: rawURL, Redirects: []redirectHop{}}
+	retried := false
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, result.FinalURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		setUpstreamHeaders(req, opts)
+		if v := opts.revalidate; v != nil && v.URL == result.FinalURL {
+			if v.ETag != "" {
+				req.Header.Set("If-None-Match", v.ETag)
+			}
+			if v.LastModified != "" {
+				req.Header.Set("If-Modified-Since", v.LastModified)
+			}
+		}
+		if err := checkRobots(ctx, req); err != nil {
+			return nil, err
+		}
+
+		release, err := acquireHost(ctx, req.URL.Host, crawlDelay(ctx, req))
+		if err != nil {
+			return nil, err
+		}
+		startUpstreamClock(ctx)
+
+		logrus.Debug("Visiting ", result.FinalURL)
+		startTime := time.Now()
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			backoffHost(req.URL.Host, resp)
+			if !retried {
+				// Queue up behind the back off and try once more
+				resp.Body.Close()
+				release()
+				retried = true
+				continue
+			}
+		}
+
+		location, err := resp.Location()
+		if !isRedirect(resp.StatusCode) || err != nil {
+			// Not a redirect, or one without a usable Location header
+			resp.Body = limitBody(resp.Body, maxBodyBytes, release)
+			result.Response = resp
+			return result, nil
+		}
+		resp.Body.Close()
+		release()
+
+		result.Redirects = append(result.Redirects, redirectHop{
+			URL:      result.FinalURL,
+			Status:   resp.StatusCode,
+			Location: location.String(),
+			Duration: int(time.Since(startTime).Milliseconds()),
+		})
+		if len(result.Redirects) > maxRedirects {
+			return nil, fmt.Errorf("%w: stopped after %d", errTooManyRedirects, maxRedirects)
+		}
+		result.FinalURL = location.String()
+	}
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// withUpstreamTimeout bounds everything one extraction fetches, redirects
+// and follow-up fetches included, by LINK2JSON_UPSTREAM_TIMEOUT. The clock
+// starts once the first fetch has its host slot, so time spent queued behind
+// other fetches to a busy or Crawl-delayed host doesn't count.
+func withUpstreamTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if totalTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	d := &upstreamDeadline{timeout: totalTimeout}
+	d.Context, d.cancel = context.WithCancel(ctx)
+	return d, d.stop
+}
+
+// upstreamDeadline is a context that times out a while after
+// startUpstreamClock is first called on it, rather than after it is created.
+type upstreamDeadline struct {
+	context.Context
+	cancel   context.CancelFunc
+	timeout  time.Duration
+	start    sync.Once
+	timer    *time.Timer
+	mu       sync.Mutex   // Guards timer between start and stop
+	deadline atomic.Int64 // Unix nanoseconds, zero until the clock starts
+	expired  atomic.Bool
+}
+
+type upstreamDeadlineKey struct{}
+
+func (d *upstreamDeadline) Deadline() (time.Time, bool) {
+	if deadline := d.deadline.Load(); deadline != 0 {
+		return time.Unix(0, deadline), true
+	}
+	return d.Context.Deadline()
+}
+
+func (d *upstreamDeadline) Err() error {
+	if err := d.Context.Err(); err != nil && d.expired.Load() {
+		return context.DeadlineExceeded
+	}
+	return d.Context.Err()
+}
+
+func (d *upstreamDeadline) Value(key any) any {
+	if key == (upstreamDeadlineKey{}) {
+		return d
+	}
+	return d.Context.Value(key)
+}
+
+func (d *upstreamDeadline) stop() {
+	d.mu.Lock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.mu.Unlock()
+	d.cancel()
+}
+
+// startUpstreamClock starts the timeout of the withUpstreamTimeout context
+// ctx belongs to, if it hasn't started yet.
+func startUpstreamClock(ctx context.Context) {
+	d, ok := ctx.Value(upstreamDeadlineKey{}).(*upstreamDeadline)
+	if !ok {
+		return
+	}
+	d.start.Do(func() {
+		d.deadline.Store(time.Now().Add(d.timeout).UnixNano())
+		d.mu.Lock()
+		d.timer = time.AfterFunc(d.timeout, func() {
+			d.expired.Store(true)
+			d.cancel()
+		})
+		d.mu.Unlock()
+	})
+}
+
+// fetchDocument downloads rawURL and parses the response body as UTF-8 HTML,
+// failing with errNotHTML for any other kind of resource.
+func fetchDocument(ctx context.Context, rawURL string, opts extractOptions) (*goquery.Document, *upstreamResponse, error) {
+	resp, err := fetch(ctx, rawURL, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, nil, fmt.Errorf("upstream returned %s", resp.Status)
+	}
+
+	mediaType, _, body := sniffContentType(resp)
+	if !isHTML(mediaType) {
+		return nil, nil, errNotHTML
+	}
+	body, _, _ = decodeHTML(body, resp.Header.Get("Content-Type"))
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, resp, nil
+}
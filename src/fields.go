@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseShape is how a client asked an /extract response to be cut down or
+// extended. ?fields= lists the fields to keep as dotted paths, where a path
+// through a list applies to each element, so images.url keeps the URL of
+// every image. ?include= opts into the optional sections, and a section
+// prefixed with a minus, such as -redirects, opts out of it. The response has
+// the redirects as it always had unless they are left out that way, and no
+// article or JSON-LD unless asked for.
+type responseShape struct {
+	fields    [][]string // Paths split on dots, nil keeps everything
+	article   bool
+	jsonld    bool
+	redirects bool
+}
+
+// responseFields are the top level fields of an /extract response.
+var responseFields = jsonFields(reflect.TypeOf(extractResponse{}))
+
+// responseShapeFromRequest reads ?fields= and ?include=. Naming a section in
+// fields includes it too.
+func responseShapeFromRequest(c *gin.Context) (responseShape, error) {
+	shape, err := responseShapeFromInclude(splitList(c.Query("include")))
+	if err != nil {
+		return shape, err
+	}
+	for _, field := range splitList(c.Query("fields")) {
+		path := strings.Split(field, ".")
+		if !responseFields[path[0]] {
+			return shape, fmt.Errorf("Unknown field: %s", field)
+		}
+		shape.set(path[0], true)
+		shape.fields = append(shape.fields, path)
+	}
+	return shape, nil
+}
+
+// responseShapeFromInclude returns the shape the include sections ask for,
+// as given to ?include= or in the include list of a job.
+func responseShapeFromInclude(sections []string) (responseShape, error) {
+	shape := responseShape{redirects: true}
+	for _, section := range sections {
+		name, exclude := strings.CutPrefix(section, "-")
+		if !shape.set(name, !exclude) {
+			return shape, fmt.Errorf("Unknown include: %s", section)
+		}
+	}
+	return shape, nil
+}
+
+// set turns section on or off, reporting whether it is an optional one.
+func (s *responseShape) set(section string, on bool) bool {
+	switch section {
+	case "article":
+		s.article = on
+	case "jsonld":
+		s.jsonld = on
+	case "redirects":
+		s.redirects = on
+	default:
+		return false
+	}
+	return true
+}
+
+// jsonFields returns the JSON names of the fields of struct type t,
+// including those of embedded structs.
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			for name := range jsonFields(embedded) {
+				fields[name] = true
+			}
+			continue
+		}
+		if name != "-" && field.IsExported() {
+			if name == "" {
+				name = field.Name
+			}
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// details reports whether the shape needs cachedDetails.
+func (s responseShape) details() bool {
+	return s.article || s.jsonld
+}
+
+// addDetails fills in the article and JSON-LD of response if the shape asks
+// for them, taken from finalURL where the metadata of url came from.
+func (s responseShape) addDetails(ctx context.Context, url, finalURL string, opts extractOptions, response *extractResponse) error {
+	if !s.details() {
+		return nil
+	}
+	details, err := cachedDetails(ctx, url, finalURL, opts)
+	if err != nil {
+		return err
+	}
+	if s.article {
+		response.Article = details.Article
+	}
+	if s.jsonld {
+		response.JSONLD = details.JSONLD
+	}
+	return nil
+}
+
+// trims reports whether responses need to go through apply.
+func (s responseShape) trims() bool {
+	return s.fields != nil || !s.redirects
+}
+
+// apply returns response as a JSON object with only the requested fields.
+func (s responseShape) apply(response extractResponse) (map[string]any, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	if !s.redirects {
+		delete(payload, "redirects")
+	}
+	if s.fields == nil {
+		return payload, nil
+	}
+	return selectFields(payload, s.fields).(map[string]any), nil
+}
+
+// selectFields keeps the parts of value the paths lead to. Lists are walked
+// through, and paths into anything else are cut short there.
+func selectFields(value any, paths [][]string) any {
+	switch v := value.(type) {
+	case []any:
+		selected := make([]any, len(v))
+		for i, element := range v {
+			selected[i] = selectFields(element, paths)
+		}
+		return selected
+	case map[string]any:
+		selected := map[string]any{}
+		nested := map[string][][]string{}
+		for _, path := range paths {
+			field, ok := v[path[0]]
+			if !ok {
+				continue
+			}
+			if len(path) == 1 {
+				selected[path[0]] = field
+			} else {
+				nested[path[0]] = append(nested[path[0]], path[1:])
+			}
+		}
+		for name, rest := range nested {
+			// A whole field beats parts of it
+			if _, whole := selected[name]; !whole {
+				selected[name] = selectFields(v[name], rest)
+			}
+		}
+		return selected
+	default:
+		return value
+	}
+}
+
+// splitList splits a comma separated query parameter, dropping empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
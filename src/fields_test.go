@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseShapeFromRequest(t *testing.T) {
+	tests := []struct {
+		query     string
+		redirects bool
+		article   bool
+		err       string
+	}{
+		{"", true, false, ""},
+		{"include=article", true, true, ""},
+		{"include=jsonld,-redirects", false, false, ""},
+		{"include=-redirects&fields=title,redirects.url", true, false, ""},
+		{"fields=title,images.url,article.text", true, true, ""},
+		{"include=comments", true, false, "Unknown include: comments"},
+		{"fields=title,tittle", true, false, "Unknown field: tittle"},
+		{"fields=images.url,imagez.url", true, false, "Unknown field: imagez.url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/extract?"+tt.query, nil)
+			shape, err := responseShapeFromRequest(c)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if shape.redirects != tt.redirects || shape.article != tt.article {
+				t.Errorf("redirects %v, article %v, want %v and %v", shape.redirects, shape.article, tt.redirects, tt.article)
+			}
+		})
+	}
+}
+
+func TestResponseFields(t *testing.T) {
+	for _, field := range []string{"title", "images", "url", "canonical_url", "redirects", "explain", "article", "jsonld"} {
+		if !responseFields[field] {
+			t.Errorf("%s is missing", field)
+		}
+	}
+}
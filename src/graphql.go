@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// graphqlLink is what the extract field resolves to. The metadata is loaded
+// up front, the details only if a query selects article or jsonld.
+type graphqlLink struct {
+	ctx         context.Context
+	url         string
+	opts        extractOptions
+	response    *extractResponse
+	cacheStatus string
+}
+
+// jsonScalar passes JSON-LD through as is, whatever its shape.
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Arbitrary JSON, as found in the page",
+	Serialize:   func(value any) any { return value },
+	ParseValue:  func(value any) any { return value },
+	ParseLiteral: func(valueAST ast.Value) any {
+		return valueAST.GetValue()
+	},
+})
+
+var graphqlSchema = func() graphql.Schema {
+	image := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Image",
+		Fields: graphql.Fields{
+			"url":    &graphql.Field{Type: graphql.String},
+			"alt":    &graphql.Field{Type: graphql.String},
+			"type":   &graphql.Field{Type: graphql.String},
+			"width":  &graphql.Field{Type: graphql.Int},
+			"height": &graphql.Field{Type: graphql.Int},
+		},
+	})
+	redirect := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Redirect",
+		Fields: graphql.Fields{
+			"url":      &graphql.Field{Type: graphql.String},
+			"status":   &graphql.Field{Type: graphql.Int},
+			"location": &graphql.Field{Type: graphql.String},
+			"duration": &graphql.Field{Type: graphql.Int, Description: "Milliseconds"},
+		},
+	})
+	articleType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Article",
+		Fields: graphql.Fields{
+			"title":       &graphql.Field{Type: graphql.String},
+			"byline":      &graphql.Field{Type: graphql.String},
+			"text":        &graphql.Field{Type: graphql.String},
+			"wordCount":   &graphql.Field{Type: graphql.Int, Resolve: articleField(func(a *article) any { return a.WordCount })},
+			"readingTime": &graphql.Field{Type: graphql.Int, Description: "Minutes", Resolve: articleField(func(a *article) any { return a.ReadingTime })},
+		},
+	})
+
+	link := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Link",
+		Fields: graphql.Fields{
+			"url":          &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.URL })},
+			"title":        &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.Title })},
+			"description":  &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.Description })},
+			"sitename":     &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.Sitename })},
+			"favicon":      &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.Favicon })},
+			"domain":       &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.Domain })},
+			"canonicalUrl": &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.CanonicalURL })},
+			"finalUrl":     &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.FinalURL })},
+			"contentType":  &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.ContentType })},
+			"charset":      &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.Charset })},
+			"language":     &graphql.Field{Type: graphql.String, Resolve: linkField(func(r *extractResponse) any { return r.Language })},
+			"redirects":    &graphql.Field{Type: graphql.NewList(redirect), Resolve: linkField(func(r *extractResponse) any { return r.Redirects })},
+			"cache": &graphql.Field{
+				Type:        graphql.String,
+				Description: "HIT, STALE, MISS or SHARED, as in the X-Cache header",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source.(*graphqlLink).cacheStatus, nil
+				},
+			},
+			"images": &graphql.Field{
+				Type: graphql.NewList(image),
+				Args: graphql.FieldConfigArgument{
+					"limit": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					images := p.Source.(*graphqlLink).response.Images
+					if limit, ok := p.Args["limit"].(int); ok && limit >= 0 && limit < len(images) {
+						images = images[:limit]
+					}
+					return images, nil
+				},
+			},
+			"article": &graphql.Field{
+				Type:        articleType,
+				Description: "Main text of the page, extracted on demand",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					details, err := p.Source.(*graphqlLink).details()
+					if err != nil {
+						return nil, err
+					}
+					return details.Article, nil
+				},
+			},
+			"jsonld": &graphql.Field{
+				Type:        graphql.NewList(jsonScalar),
+				Description: "JSON-LD objects on the page, extracted on demand",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					details, err := p.Source.(*graphqlLink).details()
+					if err != nil {
+						return nil, err
+					}
+					return details.JSONLD, nil
+				},
+			},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"extract": &graphql.Field{
+				Type: link,
+				Args: graphql.FieldConfigArgument{
+					"url":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"ua":       &graphql.ArgumentConfig{Type: graphql.String},
+					"lang":     &graphql.ArgumentConfig{Type: graphql.String},
+					"hreflang": &graphql.ArgumentConfig{Type: graphql.Boolean},
+				},
+				Resolve: resolveExtract,
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}()
+
+// linkField resolves a Link field from the extracted metadata.
+func linkField(get func(*extractResponse) any) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		return get(p.Source.(*graphqlLink).response), nil
+	}
+}
+
+// articleField resolves an Article field named unlike its JSON tag.
+func articleField(get func(*article) any) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (any, error) {
+		return get(p.Source.(*article)), nil
+	}
+}
+
+// resolveExtract takes a rate limiter token for every extract field, aliases
+// let one query ask for as many links as it likes.
+func resolveExtract(p graphql.ResolveParams) (any, error) {
+	if !rateLimiter.Allow() {
+		return nil, errors.New("Too many requests")
+	}
+	url, _ := p.Args["url"].(string)
+	opts := extractOptions{}
+	opts.AcceptLanguage, _ = p.Args["lang"].(string)
+	opts.FollowHreflang, _ = p.Args["hreflang"].(bool)
+	if ua, _ := p.Args["ua"].(string); ua != "" {
+		if _, ok := lookupProfile(ua); !ok {
+			return nil, errors.New("Unknown user agent profile: " + ua)
+		}
+		opts.Profile = strings.ToLower(ua)
+	}
+
+	response, cacheStatus, err := extractURL(p.Context, url, opts, false)
+	if err != nil {
+		_, message, _ := describeFetchError(err)
+		return nil, errors.New(message)
+	}
+	return &graphqlLink{ctx: p.Context, url: url, opts: opts, response: response, cacheStatus: cacheStatus}, nil
+}
+
+// details loads the article and JSON-LD of the link.
+func (l *graphqlLink) details() (*pageDetails, error) {
+	details, err := cachedDetails(l.ctx, l.url, l.response.FinalURL, l.opts)
+	if err != nil {
+		_, message, _ := describeFetchError(err)
+		return nil, errors.New(message)
+	}
+	return details, nil
+}
+
+// graphqlHandler runs a GraphQL query, sent as JSON in a POST body or as
+// query parameters on a GET. The rate limit is charged per extract field
+// rather than per request.
+func graphqlHandler(c *gin.Context) {
+	var req struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GraphQL request"})
+			return
+		}
+	} else {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variables"})
+				return
+			}
+		}
+	}
+	if req.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query is required"})
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        c.Request.Context(),
+	})
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+func TestGraphQLChargesPerExtract(t *testing.T) {
+	setupExtraction(t)
+	defer func(l *rate.Limiter) { rateLimiter = l }(rateLimiter)
+	rateLimiter = rate.NewLimiter(0, 2)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>Page %s</title></head></html>`, r.URL.Path[1:])
+	}))
+	defer upstream.Close()
+
+	query := fmt.Sprintf(`{ a: extract(url: "%[1]s/a") { title } b: extract(url: "%[1]s/b") { title } c: extract(url: "%[1]s/c") { title } }`, upstream.URL)
+	body, _ := json.Marshal(map[string]string{"query": query})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	graphqlHandler(c)
+
+	var result struct {
+		Data   map[string]*struct{ Title string } `json:"data"`
+		Errors []struct{ Message string }         `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	// Fields resolve in no particular order, any one of them may miss out
+	extracted := 0
+	for _, link := range result.Data {
+		if link != nil {
+			extracted++
+		}
+	}
+	if extracted != 2 {
+		t.Errorf("two of three extracts should fit in the limit: %s", w.Body)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Message != "Too many requests" {
+		t.Errorf("errors %+v", result.Errors)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+//go:generate buf generate proto
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"link-to-json/linkpb"
+)
+
+// grpcServer implements the Link2JSON service on top of the same pipeline,
+// cache and rate limiter as the REST endpoints.
+type grpcServer struct {
+	linkpb.UnimplementedLink2JSONServer
+}
+
+// serveGRPC starts the gRPC API on LINK2JSON_GRPC_PORT, if set.
+func serveGRPC() error {
+	port := os.Getenv("LINK2JSON_GRPC_PORT")
+	if port == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+
+	server := newGRPCServer()
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logrus.Error("gRPC server stopped: ", err)
+		}
+	}()
+	logrus.Info("gRPC server started on port: ", port)
+	return nil
+}
+
+// newGRPCServer returns a gRPC server with the Link2JSON service registered.
+func newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcRateLimit),
+		grpc.StreamInterceptor(grpcStreamRateLimit),
+	)
+	linkpb.RegisterLink2JSONServer(server, grpcServer{})
+	reflection.Register(server)
+	return server
+}
+
+// grpcRateLimit shares the REST rate limiter, so a client can't get around
+// it by switching protocols. The batch RPCs are charged per URL by
+// grpcBatchOptions instead.
+func grpcRateLimit(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if info.FullMethod != linkpb.Link2JSON_BatchExtract_FullMethodName && !rateLimiter.Allow() {
+		return nil, status.Error(codes.ResourceExhausted, "Too many requests")
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamRateLimit(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if info.FullMethod != linkpb.Link2JSON_StreamExtract_FullMethodName && !rateLimiter.Allow() {
+		return status.Error(codes.ResourceExhausted, "Too many requests")
+	}
+	return handler(srv, ss)
+}
+
+func (grpcServer) Extract(ctx context.Context, req *linkpb.ExtractRequest) (*linkpb.ExtractResponse, error) {
+	if req.Url == "" {
+		return nil, status.Error(codes.InvalidArgument, "URL parameter is required")
+	}
+	opts, err := grpcOptions(req.Ua, req.Lang, req.Hreflang)
+	if err != nil {
+		return nil, err
+	}
+	response, cacheStatus, err := extractURL(ctx, req.Url, opts, req.Explain)
+	if err != nil {
+		httpStatus, message, _ := describeFetchError(err)
+		return nil, status.Error(grpcCode(httpStatus), message)
+	}
+	return toProtoResponse(response, cacheStatus), nil
+}
+
+func (grpcServer) BatchExtract(ctx context.Context, req *linkpb.BatchExtractRequest) (*linkpb.BatchExtractResponse, error) {
+	opts, err := grpcBatchOptions(req)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*linkpb.BatchItem, len(req.Urls))
+	for result := range streamBatch(ctx, req.Urls, opts) {
+		items[result.Index] = toProtoItem(result)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return &linkpb.BatchExtractResponse{Items: items}, nil
+}
+
+func (grpcServer) StreamExtract(req *linkpb.BatchExtractRequest, stream linkpb.Link2JSON_StreamExtractServer) error {
+	opts, err := grpcBatchOptions(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	for result := range streamBatch(ctx, req.Urls, opts) {
+		if err := stream.Send(toProtoItem(result)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcOptions validates the fetch options shared by the RPCs, like
+// extractOptionsFromRequest does for REST.
+func grpcOptions(ua, lang string, hreflang bool) (extractOptions, error) {
+	opts := extractOptions{AcceptLanguage: lang, FollowHreflang: hreflang}
+	if ua != "" {
+		if _, ok := lookupProfile(ua); !ok {
+			return opts, status.Errorf(codes.InvalidArgument, "Unknown user agent profile: %s", ua)
+		}
+		opts.Profile = strings.ToLower(ua)
+	}
+	return opts, nil
+}
+
+// grpcBatchOptions validates a batch request and takes a rate limiter token
+// for each of its URLs.
+func grpcBatchOptions(req *linkpb.BatchExtractRequest) (extractOptions, error) {
+	if len(req.Urls) == 0 {
+		return extractOptions{}, status.Error(codes.InvalidArgument, "URL parameter is required")
+	}
+	if limit := batchLimit(); len(req.Urls) > limit {
+		return extractOptions{}, status.Errorf(codes.InvalidArgument, "Too many URLs, the limit is %d", limit)
+	}
+	opts, err := grpcOptions(req.Ua, req.Lang, req.Hreflang)
+	if err != nil {
+		return opts, err
+	}
+	// Every URL is an extraction, so every URL takes a token
+	if !rateLimiter.AllowN(time.Now(), len(req.Urls)) {
+		return opts, status.Error(codes.ResourceExhausted, "Too many requests")
+	}
+	return opts, nil
+}
+
+// grpcCode maps the HTTP status REST would answer with to a gRPC code.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusOK:
+		return codes.OK
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Unavailable
+	}
+}
+
+func toProtoItem(result streamResult) *linkpb.BatchItem {
+	item := &linkpb.BatchItem{
+		Index:  int32(result.Index),
+		Url:    result.URL,
+		Status: int32(result.Status),
+		Error:  result.Error,
+		Code:   result.Code,
+	}
+	if result.Result != nil {
+		item.Result = toProtoResponse(result.Result, result.Cache)
+	}
+	return item
+}
+
+func toProtoResponse(r *extractResponse, cacheStatus string) *linkpb.ExtractResponse {
+	pb := &linkpb.ExtractResponse{
+		Title:        r.Title,
+		Description:  r.Description,
+		Sitename:     r.Sitename,
+		Favicon:      r.Favicon,
+		Duration:     int32(r.Duration),
+		Domain:       r.Domain,
+		Url:          r.URL,
+		CanonicalUrl: r.CanonicalURL,
+		FinalUrl:     r.FinalURL,
+		ContentType:  r.ContentType,
+		Charset:      r.Charset,
+		Language:     r.Language,
+		Cache:        cacheStatus,
+	}
+	for _, img := range r.Images {
+		pb.Images = append(pb.Images, &linkpb.Image{
+			Url:    img.URL,
+			Alt:    img.Alt,
+			Type:   img.Type,
+			Width:  int32(img.Width),
+			Height: int32(img.Height),
+		})
+	}
+	for _, hop := range r.Redirects {
+		pb.Redirects = append(pb.Redirects, &linkpb.RedirectHop{
+			Url:      hop.URL,
+			Status:   int32(hop.Status),
+			Location: hop.Location,
+			Duration: int32(hop.Duration),
+		})
+	}
+	if f := r.File; f != nil {
+		pb.File = &linkpb.FileInfo{
+			Size:            f.Size,
+			Width:           int32(f.Width),
+			Height:          int32(f.Height),
+			Author:          f.Author,
+			Pages:           int32(f.Pages),
+			DurationSeconds: f.Duration,
+			Codecs:          f.Codecs,
+		}
+	}
+	if len(r.Explain) > 0 {
+		pb.Explain = make(map[string]*linkpb.FieldSource, len(r.Explain))
+		for field, src := range r.Explain {
+			pb.Explain[field] = &linkpb.FieldSource{Source: src.Source, Selector: src.Selector, Confidence: src.Confidence}
+		}
+	}
+	return pb
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"link-to-json/linkpb"
+)
+
+// grpcTestClient serves the gRPC API over an in-memory listener and returns
+// a client connected to it.
+func grpcTestClient(t *testing.T) linkpb.Link2JSONClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer()
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return linkpb.NewLink2JSONClient(conn)
+}
+
+func TestGRPCExtract(t *testing.T) {
+	setupExtraction(t)
+	defer func(l *rate.Limiter) { rateLimiter = l }(rateLimiter)
+	rateLimiter = rate.NewLimiter(rate.Inf, 1)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a", "/b":
+			fmt.Fprintf(w, `<html><head><title>Page %s</title></head><body><p>Long enough paragraph of text, with commas, to count.</p></body></html>`, r.URL.Path[1:])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	client := grpcTestClient(t)
+	ctx := context.Background()
+
+	resp, err := client.Extract(ctx, &linkpb.ExtractRequest{Url: upstream.URL + "/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Title != "Page a" || resp.FinalUrl != upstream.URL+"/a" || resp.Cache != "MISS" {
+		t.Errorf("first extract: title %q, final URL %q, cache %q", resp.Title, resp.FinalUrl, resp.Cache)
+	}
+	if resp, err = client.Extract(ctx, &linkpb.ExtractRequest{Url: upstream.URL + "/a"}); err != nil || resp.Cache != "HIT" {
+		t.Errorf("second extract: %v, cache %q", err, resp.GetCache())
+	}
+
+	if _, err := client.Extract(ctx, &linkpb.ExtractRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("extract without a URL: %v, want InvalidArgument", err)
+	}
+	if _, err := client.Extract(ctx, &linkpb.ExtractRequest{Url: upstream.URL + "/missing"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("extract of a missing page: %v, want Unavailable", err)
+	}
+
+	stream, err := client.StreamExtract(ctx, &linkpb.BatchExtractRequest{Urls: []string{upstream.URL + "/a", upstream.URL + "/b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := map[int32]string{}
+	for {
+		item, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles[item.Index] = item.GetResult().GetTitle()
+	}
+	if titles[0] != "Page a" || titles[1] != "Page b" {
+		t.Errorf("streamed titles %v", titles)
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	defer func(l *rate.Limiter) { rateLimiter = l }(rateLimiter)
+	rateLimiter = rate.NewLimiter(0, 0)
+
+	client := grpcTestClient(t)
+	if _, err := client.Extract(context.Background(), &linkpb.ExtractRequest{Url: "https://example.com/"}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("extract over the limit: %v, want ResourceExhausted", err)
+	}
+}
+
+func TestGRPCBatchChargesPerURL(t *testing.T) {
+	setupExtraction(t)
+	defer func(l *rate.Limiter) { rateLimiter = l }(rateLimiter)
+	rateLimiter = rate.NewLimiter(rate.Every(time.Hour), 3)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Batched</title></head></html>`)
+	}))
+	defer upstream.Close()
+	urls := []string{upstream.URL + "/a", upstream.URL + "/b"}
+
+	client := grpcTestClient(t)
+	ctx := context.Background()
+	if _, err := client.BatchExtract(ctx, &linkpb.BatchExtractRequest{Urls: append(urls, urls...)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("batch larger than the burst: %v, want InvalidArgument", err)
+	}
+	if _, err := client.BatchExtract(ctx, &linkpb.BatchExtractRequest{Urls: urls}); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.StreamExtract(ctx, &linkpb.BatchExtractRequest{Urls: urls})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("stream over the limit: %v, want ResourceExhausted", err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	URL "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite" // Pure Go, so the static CGO_ENABLED=0 build keeps working
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+var (
+	historyDB *sql.DB // Nil when the history store is turned off
+
+	pendingSnapshots sync.WaitGroup // Snapshots still being written in the background
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS snapshots (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	url           TEXT    NOT NULL,
+	options       TEXT    NOT NULL DEFAULT '', -- Profile and language the page was extracted with
+	canonical_url TEXT    NOT NULL,
+	domain        TEXT    NOT NULL,
+	fetched_at    INTEGER NOT NULL, -- Unix milliseconds
+	status        INTEGER NOT NULL,
+	metadata      TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS snapshots_domain ON snapshots (domain, fetched_at);
+CREATE INDEX IF NOT EXISTS snapshots_url ON snapshots (url, fetched_at);
+`
+
+// snapshot is one stored extraction, as returned by GET /history.
+type snapshot struct {
+	ID           int64           `json:"id"`
+	URL          string          `json:"url"`
+	Options      string          `json:"options,omitempty"`
+	CanonicalURL string          `json:"canonical_url"`
+	FetchedAt    time.Time       `json:"fetched_at"`
+	Status       int             `json:"status"`
+	Metadata     json.RawMessage `json:"metadata"`
+}
+
+// openHistory opens the history store named by LINK2JSON_HISTORY_DB, a
+// SQLite file defaulting to history.db. Setting it to "off" disables history.
+func openHistory() error {
+	path := os.Getenv("LINK2JSON_HISTORY_DB")
+	if path == "" {
+		path = "history.db"
+	}
+	if path == "off" {
+		return nil
+	}
+
+	db, err := openStore(path, historySchema, watchSchema, deliverySchema)
+	if err != nil {
+		return err
+	}
+	// Stores created before snapshots recorded their options
+	if err := addColumn(db, "snapshots", "options", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		db.Close()
+		return err
+	}
+	historyDB = db
+	logrus.Info("Recording extraction history in ", path)
+	return nil
+}
+
+// openStore opens the SQLite file at path and creates the tables in schemas.
+func openStore(path string, schemas ...string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, queueing on one connection beats SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	for _, stmt := range append([]string{"PRAGMA journal_mode = WAL", "PRAGMA busy_timeout = 5000"}, schemas...) {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// addColumn adds column to table unless it already has it.
+func addColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// recordSnapshot stores a successful upstream extraction under its cache
+// key, that is the normalized URL and the options it was extracted with.
+// Failing to record is logged but never fails the extraction itself.
+func recordSnapshot(key string, result *extraction) {
+	if historyDB == nil {
+		return
+	}
+	url, options := cacheKeyURL(key), cacheKeyOptions(key)
+	metadata, err := json.Marshal(newExtractResponse(url, result))
+	if err != nil {
+		logrus.Warn("Failed to encode snapshot of ", url, ": ", err)
+		return
+	}
+	var domain string
+	if u, err := URL.Parse(url); err == nil {
+		domain = u.Hostname()
+	}
+	_, err = historyDB.Exec(
+		"INSERT INTO snapshots (url, options, canonical_url, domain, fetched_at, status, metadata) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		url, options, result.CanonicalURL, domain, result.FetchedAt.UnixMilli(), result.Status, string(metadata),
+	)
+	if err != nil {
+		logrus.Warn("Failed to record snapshot of ", url, ": ", err)
+	}
+}
+
+// historyHandler lists stored extractions, newest first. They can be narrowed
+// to a domain and its subdomains, a single URL, and those fetched since a time
+// given either as RFC 3339 or as a duration ago such as 24h. Snapshots record
+// what upstream served each time a page was extracted, responses served from
+// the cache in between aren't recorded again.
+func historyHandler(c *gin.Context) {
+	if historyDB == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "History is disabled"})
+		return
+	}
+
+	query := "SELECT id, url, options, canonical_url, fetched_at, status, metadata FROM snapshots WHERE 1 = 1"
+	var args []any
+	if domain := strings.ToLower(c.Query("domain")); domain != "" {
+		query += " AND (domain = ? OR domain LIKE ? ESCAPE '\\')"
+		args = append(args, domain, "%."+escapeLike(domain))
+	}
+	if url := c.Query("url"); url != "" {
+		normalized, err := normalizeURL(url)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
+			return
+		}
+		query += " AND url = ?"
+		args = append(args, normalized)
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			d, derr := time.ParseDuration(since)
+			if derr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, use RFC 3339 or a duration"})
+				return
+			}
+			t = time.Now().Add(-d)
+		}
+		query += " AND fetched_at >= ?"
+		args = append(args, t.UnixMilli())
+	}
+	limit := defaultHistoryLimit
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 {
+		limit = min(n, maxHistoryLimit)
+	}
+	query += " ORDER BY fetched_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := historyDB.QueryContext(c.Request.Context(), query, args...)
+	if err != nil {
+		logrus.Error("Failed to query history: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query history"})
+		return
+	}
+	defer rows.Close()
+
+	snapshots := []snapshot{}
+	for rows.Next() {
+		s, err := scanSnapshot(rows)
+		if err != nil {
+			logrus.Error("Failed to read history: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query history"})
+			return
+		}
+		snapshots = append(snapshots, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	link2json "github.com/BumpyClock/go-link2json"
+)
+
+func TestSnapshotsKeyedByOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	// A store created before snapshots had options
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(`CREATE TABLE snapshots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, url TEXT NOT NULL, canonical_url TEXT NOT NULL,
+		domain TEXT NOT NULL, fetched_at INTEGER NOT NULL, status INTEGER NOT NULL, metadata TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	useHistory(t, path)
+
+	url := "https://example.com/a"
+	english := extractOptions{AcceptLanguage: "en"}.cacheKey(url)
+	german := extractOptions{AcceptLanguage: "de"}.cacheKey(url)
+	for i, key := range []string{english, german, english} {
+		recordSnapshot(key, &extraction{Metadata: &link2json.MetaDataResponseItem{}, Status: 200, FetchedAt: time.UnixMilli(int64(i + 1))})
+	}
+
+	snapshots, err := latestSnapshots(context.Background(), url, cacheKeyOptions(english), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("got %d snapshots of the English variant, want 2", len(snapshots))
+	}
+	for _, s := range snapshots {
+		if s.URL != url || s.Options != cacheKeyOptions(english) {
+			t.Errorf("snapshot %d is %q with options %q", s.ID, s.URL, s.Options)
+		}
+	}
+}
+
+// useHistory opens the history store at path for the rest of the test.
+// Snapshots earlier tests left in flight are written before it is swapped in.
+func useHistory(t *testing.T, path string) {
+	t.Helper()
+	pendingSnapshots.Wait()
+	t.Setenv("LINK2JSON_HISTORY_DB", path)
+	if err := openHistory(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pendingSnapshots.Wait()
+		historyDB.Close()
+		historyDB = nil
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	cacheControl string // Cache-Control sent with successful /extract responses
+)
+
+// loadHTTPCacheConfig reads the client-facing cache settings from the environment.
+func loadHTTPCacheConfig() {
+	cacheControl = os.Getenv("LINK2JSON_CACHE_CONTROL")
+	if cacheControl == "" {
+		cacheControl = "public, max-age=300"
+	}
+}
+
+// payloadETag derives a strong ETag from the JSON encoding of payload.
+// Callers zero per-request fields such as durations first.
+func payloadETag(payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// checkNotModified sets the ETag, Last-Modified, Cache-Control and Vary
+// headers and answers with 304 Not Modified when the client's copy is still
+// current. It returns true when it has responded.
+func checkNotModified(c *gin.Context, etag string, modified time.Time) bool {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", cacheControl)
+	// Without ?lang the client's Accept-Language picks the variant, shared
+	// caches must not hand one language's result to everyone
+	c.Writer.Header().Add("Vary", "Accept-Language")
+	if !modified.IsZero() {
+		c.Header("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since when both are sent
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		if etagMatches(inm, etag) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil && !modified.IsZero() {
+		if !modified.Truncate(time.Second).After(ims) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	loadHTTPCacheConfig()
+	const etag = `"0123abcd"`
+	modified := time.Date(2026, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+	current := modified.Format(http.TimeFormat)
+	stale := modified.Add(-time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		inm      string
+		ims      string
+		modified time.Time
+		want     bool
+	}{
+		{"unconditional", "", "", modified, false},
+		{"matching etag", etag, "", modified, true},
+		{"weak etag", "W/" + etag, "", modified, true},
+		{"etag in a list", `"other", ` + etag, "", modified, true},
+		{"any etag", "*", "", modified, true},
+		{"other etag", `"other"`, "", modified, false},
+		{"etag without quotes", "0123abcd", "", modified, false},
+		{"other etag wins over current date", `"other"`, current, modified, false},
+		{"matching etag wins over stale date", etag, stale, modified, true},
+		{"current date", "", current, modified, true},
+		{"stale date", "", stale, modified, false},
+		{"malformed date", "", "yesterday", modified, false},
+		{"date without modification time", "", current, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/extract", nil)
+			if tt.inm != "" {
+				c.Request.Header.Set("If-None-Match", tt.inm)
+			}
+			if tt.ims != "" {
+				c.Request.Header.Set("If-Modified-Since", tt.ims)
+			}
+
+			if got := checkNotModified(c, etag, tt.modified); got != tt.want {
+				t.Errorf("checkNotModified = %v, want %v", got, tt.want)
+			}
+			if got := c.Writer.Status() == http.StatusNotModified; got != tt.want {
+				t.Errorf("status %d", c.Writer.Status())
+			}
+			h := w.Header()
+			if h.Get("ETag") != etag || h.Get("Cache-Control") != cacheControl || h.Get("Vary") != "Accept-Language" {
+				t.Errorf("headers %v", h)
+			}
+			lastModified := ""
+			if !tt.modified.IsZero() {
+				lastModified = current
+			}
+			if h.Get("Last-Modified") != lastModified {
+				t.Errorf("Last-Modified %q, want %q", h.Get("Last-Modified"), lastModified)
+			}
+		})
+	}
+}
+
+func TestExtractETagIsStable(t *testing.T) {
+	setupExtraction(t)
+	loadHTTPCacheConfig()
+	defer func(l *rate.Limiter) { rateLimiter = l }(rateLimiter)
+	rateLimiter = rate.NewLimiter(rate.Inf, 1)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond) // Give the first request a duration
+		fmt.Fprint(w, `<html><head><title>Cached</title></head><body><p>Long enough paragraph of text, with commas, to count.</p></body></html>`)
+	}))
+	defer upstream.Close()
+
+	extract := func(query, inm string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/extract?url="+upstream.URL+"/page"+query, nil)
+		if inm != "" {
+			c.Request.Header.Set("If-None-Match", inm)
+		}
+		extractHandler(c)
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+
+	for _, query := range []string{"", "&fields=title,duration"} {
+		extractionCache.Flush()
+		first := extract(query, "")
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%q: first request answered %d with ETag %q", query, first.Code, etag)
+		}
+		if first.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("%q: first request was a cache %s", query, first.Header().Get("X-Cache"))
+		}
+		if second := extract(query, ""); second.Header().Get("ETag") != etag {
+			t.Errorf("%q: ETag changed from %s to %s", query, etag, second.Header().Get("ETag"))
+		}
+		if w := extract(query, "W/"+etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("%q: revalidation answered %d %s", query, w.Code, w.Body)
+		}
+		if w := extract(query, `"stale"`); w.Code != http.StatusOK {
+			t.Errorf("%q: stale ETag answered %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Job statuses, in the order a job goes through them.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed" // The job itself couldn't run, failed URLs alone don't fail a job
+)
+
+const (
+	maxJobURLs        = 1000
+	jobURLConcurrency = 4 // URLs of one job extracted at once
+)
+
+var (
+	jobWorkers    int           // Jobs run at once
+	maxQueuedJobs int           // Jobs waiting beyond this are turned away
+	jobTimeout    time.Duration // Per job, URLs still pending when it passes fail
+	jobRetention  time.Duration // How long finished jobs are kept for GET /jobs/{id}
+	jobURLLimiter *rate.Limiter // Shared quota of URLs jobs may queue, charged per URL
+
+	jobWake chan struct{} // Nudges idle workers when a job is queued
+
+	jobsDB *sql.DB // Nil when the job queue is turned off
+)
+
+const jobSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id          TEXT    PRIMARY KEY,
+	status      TEXT    NOT NULL,
+	request     TEXT    NOT NULL, -- JSON jobRequest
+	results     TEXT,             -- JSON []batchResult once finished
+	total       INTEGER NOT NULL,
+	completed   INTEGER NOT NULL DEFAULT 0,
+	error       TEXT,
+	created_at  INTEGER NOT NULL, -- Unix milliseconds, as are the other times
+	started_at  INTEGER,
+	finished_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS jobs_status ON jobs (status, created_at);
+`
+
+// jobRequest is the body of POST /jobs. The options mirror the /extract
+// query parameters and apply to every URL.
+type jobRequest struct {
+	URLs        []string `json:"urls"`
+	UA          string   `json:"ua"`
+	Lang        string   `json:"lang"`
+	Hreflang    bool     `json:"hreflang"`
+	Include     []string `json:"include"`      // Sections as for /extract?include=, such as article
+	CallbackURL string   `json:"callback_url"` // Optional webhook called once the job finishes
+	Secret      string   `json:"secret"`       // Optional HMAC key for signing the callback
+}
+
+// batchResult is the outcome of one URL of a job or stream.
+type batchResult struct {
+	URL     string           `json:"url"`
+	Status  int              `json:"status"` // HTTP status /extract would have answered with
+	Cache   string           `json:"cache,omitempty"`
+	Error   string           `json:"error,omitempty"`
+	Code    string           `json:"code,omitempty"`
+	Result  *extractResponse `json:"-"`                // For the gRPC API, which builds its own messages
+	Payload any              `json:"result,omitempty"` // Result as sent, cut down to a responseShape for jobs
+}
+
+// jobView is a job as returned by GET /jobs/{id}.
+type jobView struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Total      int             `json:"total"`
+	Completed  int             `json:"completed"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Results    json.RawMessage `json:"results,omitempty"`
+}
+
+// loadJobConfig reads the job queue settings from the environment.
+func loadJobConfig() {
+	jobWorkers = max(envInt("LINK2JSON_JOB_WORKERS", 2), 1)
+	maxQueuedJobs = envInt("LINK2JSON_MAX_QUEUED_JOBS", 1000)
+	jobTimeout = envDuration("LINK2JSON_JOB_TIMEOUT", 10*time.Minute)
+	jobRetention = envDuration("LINK2JSON_JOB_RETENTION", 24*time.Hour)
+	// The same 1 extraction per second as /extract, with room for one full job
+	jobURLLimiter = rate.NewLimiter(rate.Limit(envInt("LINK2JSON_JOB_URL_RATE", 1)), max(envInt("LINK2JSON_JOB_URL_BURST", maxJobURLs), 1))
+}
+
+// openJobs opens the job queue named by LINK2JSON_JOBS_DB, a SQLite file
+// defaulting to jobs.db. It is kept apart from the history store so either
+// can be turned off on its own, setting it to "off" disables POST /jobs.
+func openJobs() error {
+	path := os.Getenv("LINK2JSON_JOBS_DB")
+	if path == "" {
+		path = "jobs.db"
+	}
+	if path == "off" {
+		return nil
+	}
+	db, err := openStore(path, jobSchema)
+	if err != nil {
+		return err
+	}
+	jobsDB = db
+	logrus.Info("Queueing jobs in ", path)
+	return nil
+}
+
+// startJobWorkers requeues the jobs a previous run was in the middle of and
+// starts the worker pool. Jobs live in their own database, so queued ones
+// survive restarts.
+func startJobWorkers() {
+	if _, err := jobsDB.Exec("UPDATE jobs SET status = ?, started_at = NULL, completed = 0 WHERE status = ?", jobQueued, jobRunning); err != nil {
+		logrus.Error("Failed to requeue interrupted jobs: ", err)
+	}
+	jobWake = make(chan struct{}, jobWorkers)
+	for range jobWorkers {
+		go jobWorker()
+	}
+	go pruneJobs()
+}
+
+// jobWorker runs queued jobs oldest first, polling now and then in case a
+// wake-up was missed.
+func jobWorker() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		id, req, err := claimJob()
+		if errors.Is(err, sql.ErrNoRows) {
+			select {
+			case <-jobWake:
+			case <-ticker.C:
+			}
+			continue
+		}
+		if err != nil {
+			logrus.Error("Failed to claim job: ", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		runJob(id, req)
+	}
+}
+
+// claimJob marks the oldest queued job running and returns it.
+func claimJob() (string, jobRequest, error) {
+	var id, request string
+	var req jobRequest
+	err := jobsDB.QueryRow(
+		`UPDATE jobs SET status = ?, started_at = ?
+		WHERE id = (SELECT id FROM jobs WHERE status = ? ORDER BY created_at LIMIT 1)
+		RETURNING id, request`,
+		jobRunning, time.Now().UnixMilli(), jobQueued).Scan(&id, &request)
+	if err != nil {
+		return "", req, err
+	}
+	if err := json.Unmarshal([]byte(request), &req); err != nil {
+		finishJob(id, jobFailed, nil, "Invalid job request")
+		return "", req, fmt.Errorf("decoding job %s: %w", id, err)
+	}
+	return id, req, nil
+}
+
+// runJob extracts every URL of a job, records the results and calls the
+// job's webhook if it has one.
+func runJob(id string, req jobRequest) {
+	logrus.Info("Running job ", id, " with ", len(req.URLs), " URLs")
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+	opts := extractOptions{AcceptLanguage: req.Lang, FollowHreflang: req.Hreflang, Profile: strings.ToLower(req.UA)}
+	shape, _ := responseShapeFromInclude(req.Include) // Checked when the job was queued
+
+	results := make([]batchResult, len(req.URLs))
+	sem := make(chan struct{}, jobURLConcurrency)
+	var wg sync.WaitGroup
+	for i, url := range req.URLs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, url string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = shapeBatchResult(ctx, extractBatchURL(ctx, url, opts), opts, shape)
+			if _, err := jobsDB.Exec("UPDATE jobs SET completed = completed + 1 WHERE id = ?", id); err != nil {
+				logrus.Warn("Failed to update progress of job ", id, ": ", err)
+			}
+		}(i, url)
+	}
+	wg.Wait()
+
+	finishJob(id, jobDone, results, "")
+	if req.CallbackURL != "" {
+		view, err := loadJob(context.Background(), id)
+		if err != nil {
+			logrus.Error("Failed to load finished job ", id, ": ", err)
+			return
+		}
+		body, err := json.Marshal(gin.H{"event": "job.completed", "job": view})
+		if err != nil {
+			logrus.Error("Failed to encode webhook payload for job ", id, ": ", err)
+			return
+		}
+		go deliverWebhook("job.completed", id, req.CallbackURL, req.Secret, body)
+	}
+}
+
+// extractBatchURL extracts one URL of a batch, reporting failures in the result.
+func extractBatchURL(ctx context.Context, url string, opts extractOptions) batchResult {
+	response, cacheStatus, err := extractURL(ctx, url, opts, false)
+	if err != nil {
+		status, message, code := describeFetchError(err)
+		return batchResult{URL: url, Status: status, Cache: cacheStatus, Error: message, Code: code}
+	}
+	return batchResult{URL: url, Status: http.StatusOK, Cache: cacheStatus, Result: response, Payload: response}
+}
+
+// shapeBatchResult gives a successful result the sections shape asks for,
+// like /extract does for its response.
+func shapeBatchResult(ctx context.Context, result batchResult, opts extractOptions, shape responseShape) batchResult {
+	if result.Result == nil {
+		return result
+	}
+	if err := shape.addDetails(ctx, result.URL, result.Result.FinalURL, opts, result.Result); err != nil {
+		status, message, code := describeFetchError(err)
+		return batchResult{URL: result.URL, Status: status, Cache: result.Cache, Error: message, Code: code}
+	}
+	if shape.trims() {
+		trimmed, err := shape.apply(*result.Result)
+		if err != nil {
+			logrus.Error("Error shaping result for ", result.URL, ": ", err)
+			return batchResult{URL: result.URL, Status: http.StatusInternalServerError, Cache: result.Cache, Error: "Failed to fetch metadata"}
+		}
+		result.Payload = trimmed
+	}
+	return result
+}
+
+func finishJob(id, status string, results []batchResult, errMsg string) {
+	var encoded, errCol *string
+	if results != nil {
+		b, err := json.Marshal(results)
+		if err != nil {
+			logrus.Error("Failed to encode results of job ", id, ": ", err)
+			status, errMsg = jobFailed, "Failed to encode results"
+		} else {
+			s := string(b)
+			encoded = &s
+		}
+	}
+	if errMsg != "" {
+		errCol = &errMsg
+	}
+	_, err := jobsDB.Exec("UPDATE jobs SET status = ?, results = ?, error = ?, finished_at = ? WHERE id = ?",
+		status, encoded, errCol, time.Now().UnixMilli(), id)
+	if err != nil {
+		logrus.Error("Failed to finish job ", id, ": ", err)
+		return
+	}
+	logrus.Info("Job ", id, " ", status)
+}
+
+// pruneJobs drops finished jobs once they are past the retention period.
+func pruneJobs() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-jobRetention).UnixMilli()
+		res, err := jobsDB.Exec("DELETE FROM jobs WHERE status IN (?, ?) AND finished_at < ?", jobDone, jobFailed, cutoff)
+		if err != nil {
+			logrus.Error("Failed to prune jobs: ", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			logrus.Info("Pruned ", n, " finished jobs")
+		}
+	}
+}
+
+// createJobHandler queues a batch extraction and returns its ID straight away.
+func createJobHandler(c *gin.Context) {
+	if jobsDB == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Jobs are disabled"})
+		return
+	}
+	if !rateLimiter.Allow() {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		return
+	}
+
+	var req jobRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.URLs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A JSON body with a urls list is required"})
+		return
+	}
+	if limit := min(maxJobURLs, jobURLLimiter.Burst()); len(req.URLs) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many URLs, the limit is %d", limit)})
+		return
+	}
+	if req.UA != "" {
+		if _, ok := lookupProfile(req.UA); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown user agent profile: %s", req.UA)})
+			return
+		}
+	}
+	if _, err := responseShapeFromInclude(req.Include); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(c.Request.Context(), req.CallbackURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callback URL: " + err.Error()})
+			return
+		}
+	}
+
+	var queued int
+	if err := jobsDB.QueryRow("SELECT COUNT(*) FROM jobs WHERE status = ?", jobQueued).Scan(&queued); err != nil {
+		logrus.Error("Failed to count queued jobs: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue job"})
+		return
+	}
+	if queued >= maxQueuedJobs {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Job queue is full"})
+		return
+	}
+	// The request itself took one token above, each URL is an extraction too
+	if !jobURLLimiter.AllowN(time.Now(), len(req.URLs)) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many URLs queued, try again later"})
+		return
+	}
+
+	request, _ := json.Marshal(req)
+	id := newID()
+	_, err := jobsDB.ExecContext(c.Request.Context(),
+		"INSERT INTO jobs (id, status, request, total, created_at) VALUES (?, ?, ?, ?, ?)",
+		id, jobQueued, string(request), len(req.URLs), time.Now().UnixMilli())
+	if err != nil {
+		logrus.Error("Failed to queue job: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue job"})
+		return
+	}
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+
+	c.Header("Location", "/jobs/"+id)
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "status": jobQueued, "total": len(req.URLs)})
+}
+
+// jobHandler reports a job's progress, and its results once it is done.
+func jobHandler(c *gin.Context) {
+	if jobsDB == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Jobs are disabled"})
+		return
+	}
+	view, err := loadJob(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error("Failed to load job: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+	c.JSON(http.StatusOK, view)
+}
+
+func loadJob(ctx context.Context, id string) (*jobView, error) {
+	var view jobView
+	var results, errMsg *string
+	var created int64
+	var started, finished *int64
+	err := jobsDB.QueryRowContext(ctx,
+		"SELECT id, status, total, completed, error, created_at, started_at, finished_at, results FROM jobs WHERE id = ?", id).
+		Scan(&view.ID, &view.Status, &view.Total, &view.Completed, &errMsg, &created, &started, &finished, &results)
+	if err != nil {
+		return nil, err
+	}
+	view.CreatedAt = time.UnixMilli(created).UTC()
+	if started != nil {
+		t := time.UnixMilli(*started).UTC()
+		view.StartedAt = &t
+	}
+	if finished != nil {
+		t := time.UnixMilli(*finished).UTC()
+		view.FinishedAt = &t
+	}
+	if errMsg != nil {
+		view.Error = *errMsg
+	}
+	if results != nil {
+		view.Results = json.RawMessage(*results)
+	}
+	return &view, nil
+}
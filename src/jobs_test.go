@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+func TestJobsChargePerURL(t *testing.T) {
+	t.Setenv("LINK2JSON_JOB_URL_RATE", "1")
+	t.Setenv("LINK2JSON_JOB_URL_BURST", "4")
+	useJobs(t)
+
+	if historyDB != nil {
+		t.Fatal("history store is open, jobs should not need it")
+	}
+	for _, tt := range []struct {
+		urls string
+		want int
+	}{
+		{`"https://example.com/1", "https://example.com/2", "https://example.com/3"`, http.StatusAccepted},
+		{`"https://example.com/4", "https://example.com/5"`, http.StatusTooManyRequests},
+		{`"https://example.com/4"`, http.StatusAccepted},
+		{`"https://example.com/1", "https://example.com/2", "https://example.com/3", "https://example.com/4", "https://example.com/5"`, http.StatusBadRequest},
+	} {
+		w := queueJob(t, `{"urls": [`+tt.urls+`]}`)
+		if w.Code != tt.want {
+			t.Errorf("queueing [%s] answered %d %s, want %d", tt.urls, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+func TestJobIncludesSections(t *testing.T) {
+	setupExtraction(t)
+	useJobs(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Job</title></head><body><article><p>A paragraph long enough, with commas, to be the article.</p></article></body></html>`)
+	}))
+	defer upstream.Close()
+
+	if w := queueJob(t, `{"urls": ["`+upstream.URL+`"], "include": ["comments"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown include answered %d %s", w.Code, w.Body)
+	}
+	if w := queueJob(t, `{"urls": ["`+upstream.URL+`"], "include": ["article", "-redirects"]}`); w.Code != http.StatusAccepted {
+		t.Fatalf("queueing answered %d %s", w.Code, w.Body)
+	}
+	id, req, err := claimJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runJob(id, req)
+
+	view, err := loadJob(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []struct {
+		Status int            `json:"status"`
+		Result map[string]any `json:"result"`
+	}
+	if err := json.Unmarshal(view.Results, &results); err != nil || len(results) != 1 {
+		t.Fatalf("results %s", view.Results)
+	}
+	result := results[0].Result
+	if _, ok := result["article"].(map[string]any); !ok {
+		t.Errorf("result has no article: %v", result)
+	}
+	if _, ok := result["redirects"]; ok {
+		t.Errorf("result kept the redirects it excluded: %v", result)
+	}
+}
+
+// useJobs opens a job queue for the rest of the test, without starting
+// workers, and lifts the rate limit.
+func useJobs(t *testing.T) {
+	t.Helper()
+	t.Setenv("LINK2JSON_JOBS_DB", filepath.Join(t.TempDir(), "jobs.db"))
+	if err := openJobs(); err != nil {
+		t.Fatal(err)
+	}
+	loadJobConfig()
+	limiter := rateLimiter
+	rateLimiter = rate.NewLimiter(rate.Inf, 1)
+	t.Cleanup(func() {
+		jobsDB.Close()
+		jobsDB = nil
+		rateLimiter = limiter
+	})
+}
+
+func queueJob(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body))
+	createJobHandler(c)
+	return w
+}
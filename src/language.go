@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/abadojack/whatlanggo"
+	"golang.org/x/text/language"
+)
+
+const (
+	languageSampleChars = 2000 // Enough text for a stable trigram profile
+	maxAcceptLanguages  = 3    // Preferences past these rarely change what a site serves
+)
+
+// detectLanguage works out the language of a page from its <html lang>
+// attribute, the Content-Language header and finally a trigram detector run
+// over the visible text.
+func detectLanguage(doc *goquery.Document, contentLanguage string) (string, fieldSource) {
+	if lang := normalizeLanguageTag(doc.Find("html").AttrOr("lang", "")); lang != "" {
+		return lang, fieldSource{Source: sourceTag, Selector: "html[lang]", Confidence: 0.9}
+	}
+	if lang := normalizeLanguageTag(contentLanguage); lang != "" {
+		return lang, fieldSource{Source: sourceHeader, Selector: "Content-Language", Confidence: 0.85}
+	}
+
+	body := doc.Find("body").Clone()
+	body.Find("script, style, noscript").Remove()
+	sample := strings.Join([]string{
+		doc.Find("title").First().Text(),
+		doc.Find(`meta[name="description"]`).AttrOr("content", ""),
+		body.Text(),
+	}, " ")
+	sample = strings.Join(strings.Fields(sample), " ")
+	if len(sample) > languageSampleChars {
+		sample = strings.ToValidUTF8(sample[:languageSampleChars], "")
+	}
+
+	info := whatlanggo.Detect(sample)
+	if info.Lang < 0 || !info.IsReliable() {
+		return "", fieldSource{}
+	}
+	return info.Lang.Iso6391(), fieldSource{Source: sourceFallback, Selector: "trigram detection", Confidence: info.Confidence}
+}
+
+// normalizeLanguageTag returns the canonical BCP 47 form of the first tag in
+// value, which may be a comma separated Content-Language list.
+func normalizeLanguageTag(value string) string {
+	value, _, _ = strings.Cut(value, ",")
+	tag, err := language.Parse(strings.TrimSpace(value))
+	if err != nil || tag == language.Und {
+		return ""
+	}
+	return tag.String()
+}
+
+// normalizeAcceptLanguage reduces an Accept-Language header to its first few
+// distinct tags in canonical form and order of preference, with q-values
+// rewritten to fixed steps. Browsers send many spellings of the same
+// preferences, this way they share a cache entry and an upstream fetch.
+// An unparseable header normalizes to "", as if none was sent.
+func normalizeAcceptLanguage(value string) string {
+	tags, _, err := language.ParseAcceptLanguage(value)
+	if err != nil {
+		return ""
+	}
+	var list []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		name := tag.String()
+		// The * wildcard parses as "mul", it says nothing a site can act on
+		if tag == language.Und || name == "mul" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if len(list) > 0 {
+			name += fmt.Sprintf(";q=0.%d", 10-len(list))
+		}
+		list = append(list, name)
+		if len(list) == maxAcceptLanguages {
+			break
+		}
+	}
+	return strings.Join(list, ",")
+}
+
+// hreflangAlternate returns the alternate version of the page that best
+// matches acceptLanguage, or an empty string when the page itself is the
+// best match or nothing matches.
+func hreflangAlternate(doc *goquery.Document, base, acceptLanguage string) string {
+	requested, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(requested) == 0 {
+		return ""
+	}
+
+	// The page itself goes first so it wins ties and is the matcher's default
+	var tags []language.Tag
+	urls := []string{""}
+	if lang, err := language.Parse(doc.Find("html").AttrOr("lang", "")); err == nil {
+		tags = append(tags, lang)
+	} else {
+		tags = append(tags, language.Und)
+	}
+	doc.Find(`link[rel="alternate"][hreflang]`).Each(func(_ int, s *goquery.Selection) {
+		tag, err := language.Parse(s.AttrOr("hreflang", ""))
+		href := s.AttrOr("href", "")
+		if err != nil || href == "" {
+			return
+		}
+		tags = append(tags, tag)
+		urls = append(urls, resolveReference(base, href))
+	})
+	if len(tags) == 1 {
+		return ""
+	}
+
+	_, index, confidence := language.NewMatcher(tags).Match(requested...)
+	if confidence == language.No {
+		return ""
+	}
+	return urls[index]
+}
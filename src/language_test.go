@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestNormalizeAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"de", "de"},
+		{"de-DE,de;q=0.9,en;q=0.8", "de-DE,de;q=0.9,en;q=0.8"},
+		{"de-de, de;q=0.95, en;q=0.5", "de-DE,de;q=0.9,en;q=0.8"},
+		{"en;q=0.2,fr", "fr,en;q=0.9"},
+		{"fr,fr,en,de,es,it", "fr,en;q=0.9,de;q=0.8"},
+		{"*;q=0.5,nl", "nl"},
+		{"not a language;;;", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeAcceptLanguage(tt.in); got != tt.want {
+			t.Errorf("normalizeAcceptLanguage(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCacheKeyLanguageVariants(t *testing.T) {
+	a := extractOptions{AcceptLanguage: "de-DE,de;q=0.9"}.cacheKey("https://example.com/")
+	b := extractOptions{AcceptLanguage: "de-de, de;q=0.7"}.cacheKey("https://example.com/")
+	if a != b {
+		t.Errorf("cache keys differ: %q and %q", a, b)
+	}
+}
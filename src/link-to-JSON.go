@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	URL "net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	link2json "github.com/BumpyClock/go-link2json"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv" // Caching package
+	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/time/rate" // Rate limiter
 )
@@ -18,8 +25,24 @@ import (
 var (
 	rateLimiter = rate.NewLimiter(1, 3) // Allows 1 request per second with a burst capacity of 3
 
+	errInvalidURL = errors.New("invalid URL")
 )
 
+// extractResponse is the /extract payload: the link2json metadata plus optional annotations.
+type extractResponse struct {
+	*link2json.MetaDataResponseItem
+	CanonicalURL string                 `json:"canonical_url"`
+	FinalURL     string                 `json:"final_url"`
+	Redirects    []redirectHop          `json:"redirects"`
+	ContentType  string                 `json:"content_type"`
+	Charset      string                 `json:"charset,omitempty"`
+	Language     string                 `json:"language,omitempty"`
+	File         *fileInfo              `json:"file,omitempty"`
+	Explain      map[string]fieldSource `json:"explain,omitempty"`
+	Article      *article               `json:"article,omitempty"`
+	JSONLD       []map[string]any       `json:"jsonld,omitempty"`
+}
+
 func main() {
 	router := gin.Default()
 
@@ -28,13 +51,56 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	userAgent := os.Getenv("LINK2JSON_USER_AGENT")
-	if userAgent == "" {
-		userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.3"
+	loadProfileConfig()
+	if os.Getenv("LINK2JSON_USER_AGENT") == "" {
 		logrus.Warn("User agent not set, using default")
 	} else {
 		logrus.Info("User agent set to: ", userAgent)
 	}
+	logrus.Info("Default user agent profile: ", defaultProfile)
+
+	upstream := loadUpstreamConfig()
+	httpClient, err = newUpstreamClient(upstream)
+	if err != nil {
+		log.Fatal("Error configuring upstream client: ", err)
+	}
+	maxRedirects, maxBodyBytes, totalTimeout = upstream.MaxRedirects, upstream.MaxBodyBytes, upstream.TotalTimeout
+
+	loadThrottleConfig()
+	loadRobotsConfig()
+	if respectRobots {
+		logrus.Info("robots.txt compliance mode enabled")
+	}
+
+	loadCacheConfig()
+	loadHTTPCacheConfig()
+
+	rulesFile := os.Getenv("LINK2JSON_RULES_FILE")
+	if rulesFile == "" {
+		rulesFile = "rules.yaml"
+	}
+	go watchRules(rulesFile, 5*time.Second)
+
+	if err := openHistory(); err != nil {
+		log.Fatal("Error opening history store: ", err)
+	}
+	loadWatchConfig()
+	loadWebhookConfig()
+	if historyDB != nil {
+		go runWatches()
+	}
+	if err := openJobs(); err != nil {
+		log.Fatal("Error opening job queue: ", err)
+	}
+	loadJobConfig()
+	if jobsDB != nil {
+		startJobWorkers()
+	}
+	loadWebSocketConfig()
+	loadAdminConfig()
+	if adminToken == "" {
+		logrus.Warn("Admin token not set, admin API disabled")
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -46,41 +112,281 @@ func main() {
 	config.AllowAllOrigins = true
 	router.Use(cors.New(config))
 
-	router.GET("/extract", func(c *gin.Context) {
-		// Rate limit check
-		if !rateLimiter.Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
-			return
-		}
+	router.GET("/extract", extractHandler)
+	router.GET("/extract/stream", extractStreamHandler)
+	router.POST("/extract/stream", extractStreamHandler)
+	router.GET("/extract/ws", extractSocketHandler)
+	router.GET("/graphql", graphqlHandler)
+	router.POST("/graphql", graphqlHandler)
+	router.GET("/resolve", resolveHandler)
+	router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	router.GET("/rules/test", rulesTestHandler)
+	router.GET("/history", adminAuth(), historyHandler)
+	router.GET("/diff", adminAuth(), diffHandler)
 
-		startTime := time.Now()
-		url := c.Query("url")
-		if url == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
-			return
-		}
+	// Watches make the server fetch on a schedule and call back out, so they
+	// are managed with the admin token like the cache
+	watches := router.Group("/watches", adminAuth())
+	watches.GET("", listWatchesHandler)
+	watches.POST("", createWatchHandler)
+	watches.DELETE("", deleteWatchHandler)
+	watches.GET("/deliveries", deliveriesHandler)
 
-		// Validate the URL
-		_, err := URL.ParseRequestURI(url)
-		if err != nil {
-			logrus.Error("Invalid URL: ", url)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
-			return
-		}
+	router.POST("/jobs", createJobHandler)
+	router.GET("/jobs/:id", jobHandler)
+
+	admin := router.Group("/admin", adminAuth())
+	admin.GET("/cache", adminCacheHandler)
+	admin.DELETE("/cache", adminPurgeHandler)
+	admin.POST("/cache/warm", adminWarmHandler)
+	admin.GET("/cache/stats", adminStatsHandler)
+
+	if err := serveGRPC(); err != nil {
+		log.Fatal("Error starting gRPC server: ", err)
+	}
 
-		metadata, err := link2json.GetMetadata(url)
+	router.Run(":" + port)
+	logrus.Info("Server started on port: ", port)
+
+}
+
+func extractHandler(c *gin.Context) {
+	// Rate limit check
+	if !rateLimiter.Allow() {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		return
+	}
+
+	startTime := time.Now()
+	url := c.Query("url")
+	if url == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
+		return
+	}
+
+	// Validate the URL
+	if _, err := validateURL(url); err != nil {
+		logrus.Error("Invalid URL: ", url)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
+		return
+	}
+
+	explain, _ := strconv.ParseBool(c.Query("explain"))
+
+	opts, err := extractOptionsFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	shape, err := responseShapeFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, cacheStatus, err := cachedMetadata(c.Request.Context(), url, opts)
+	c.Header("X-Cache", cacheStatus)
+	if err != nil {
+		respondFetchError(c, url, err)
+		return
+	}
+
+	response := newExtractResponse(url, result)
+	if explain {
+		response.Explain = result.Sources
+	}
+	if err := shape.addDetails(c.Request.Context(), url, result.FinalURL, opts, &response); err != nil {
+		respondFetchError(c, url, err)
+		return
+	}
+
+	var payload any = &response
+	var trimmed map[string]any
+	if shape.trims() {
+		trimmed, err = shape.apply(response)
 		if err != nil {
+			logrus.Error("Error shaping response for ", url, ": ", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch metadata"})
 			return
 		}
+		payload = trimmed
+	}
 
-		duration := time.Since(startTime)
-		metadata.Duration = int(duration.Milliseconds())
+	etag, err := payloadETag(payload)
+	if err == nil && checkNotModified(c, etag, result.FetchedAt) {
+		return
+	}
 
-		c.JSON(http.StatusOK, metadata)
-	})
+	duration := time.Since(startTime)
+	response.Duration = int(duration.Milliseconds())
+	if _, kept := trimmed["duration"]; kept {
+		trimmed["duration"] = response.Duration
+	}
+	c.JSON(http.StatusOK, payload)
+}
 
-	router.Run(":" + port)
-	logrus.Info("Server started on port: ", port)
+// newExtractResponse builds the /extract payload for result as requested for
+// url. Cached results are shared, so the metadata is copied rather than
+// modified in place, and Duration is left for the caller to fill in.
+func newExtractResponse(url string, result *extraction) extractResponse {
+	metadata := *result.Metadata
+	metadata.URL = url
+	metadata.Duration = 0
+
+	return extractResponse{
+		MetaDataResponseItem: &metadata,
+		CanonicalURL:         result.CanonicalURL,
+		FinalURL:             result.FinalURL,
+		Redirects:            result.Redirects,
+		ContentType:          result.ContentType,
+		Charset:              result.Charset,
+		Language:             result.Language,
+		File:                 result.File,
+	}
+}
+
+// extractOptionsFromRequest reads the fetch options shared by the extraction endpoints.
+// ?lang= takes precedence over the client's own Accept-Language header.
+func extractOptionsFromRequest(c *gin.Context) (extractOptions, error) {
+	opts := extractOptions{AcceptLanguage: c.Query("lang")}
+	if opts.AcceptLanguage == "" {
+		opts.AcceptLanguage = c.GetHeader("Accept-Language")
+	}
+	opts.FollowHreflang, _ = strconv.ParseBool(c.Query("hreflang"))
+
+	if ua := c.Query("ua"); ua != "" {
+		if _, ok := lookupProfile(ua); !ok {
+			return opts, fmt.Errorf("Unknown user agent profile: %s", ua)
+		}
+		opts.Profile = strings.ToLower(ua)
+	}
+	return opts, nil
+}
+
+// queryURL reads and validates the url query parameter, answering the
+// request itself when it is missing or invalid.
+func queryURL(c *gin.Context) (string, bool) {
+	url := c.Query("url")
+	if url == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
+		return "", false
+	}
+	if _, err := validateURL(url); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
+		return "", false
+	}
+	return url, true
+}
+
+// validateURL checks that rawURL is an absolute URL we can key a cache entry
+// by, and returns its normalized form for lookups. Fetches go to rawURL.
+func validateURL(rawURL string) (string, error) {
+	if _, err := URL.ParseRequestURI(rawURL); err != nil {
+		return "", err
+	}
+	return normalizeURL(rawURL)
+}
+
+// respondFetchError answers a request whose upstream fetch of url failed.
+func respondFetchError(c *gin.Context, url string, err error) {
+	if c.Request.Context().Err() != nil {
+		logrus.Debug("Client disconnected, abandoned fetch of ", url)
+		return
+	}
+	status, message, code := describeFetchError(err)
+	if code != "" {
+		c.JSON(status, gin.H{"error": message, "code": code})
+		return
+	}
+	c.JSON(status, gin.H{"error": message})
+}
+
+// describeFetchError maps an extraction error to the HTTP status, message
+// and, where clients need to tell it apart, code we report it with.
+func describeFetchError(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, errInvalidURL):
+		return http.StatusBadRequest, "Invalid URL", ""
+	case errors.Is(err, errBlockedByRobots):
+		return http.StatusForbidden, "Blocked by robots.txt", "blocked_by_robots"
+	case isTimeout(err):
+		return http.StatusGatewayTimeout, "Timed out fetching metadata", ""
+	default:
+		return http.StatusInternalServerError, "Failed to fetch metadata", ""
+	}
+}
 
+// extractURL runs the /extract pipeline for rawURL outside of a single gin
+// request, for the endpoints that extract several URLs at once and the gRPC
+// API. It also returns the X-Cache status.
+func extractURL(ctx context.Context, rawURL string, opts extractOptions, explain bool) (*extractResponse, string, error) {
+	startTime := time.Now()
+	if _, err := validateURL(rawURL); err != nil {
+		return nil, "", errInvalidURL
+	}
+	result, cacheStatus, err := cachedMetadata(ctx, rawURL, opts)
+	if err != nil {
+		return nil, cacheStatus, err
+	}
+	response := newExtractResponse(rawURL, result)
+	if explain {
+		response.Explain = result.Sources
+	}
+	response.Duration = int(time.Since(startTime).Milliseconds())
+	return &response, cacheStatus, nil
+}
+
+// isTimeout reports whether err came from one of the upstream timeouts.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// resolveHandler follows a URL's redirects without downloading or parsing the page.
+func resolveHandler(c *gin.Context) {
+	if !rateLimiter.Allow() {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		return
+	}
+
+	startTime := time.Now()
+	url := c.Query("url")
+	if url == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
+		return
+	}
+	if _, err := URL.ParseRequestURI(url); err != nil {
+		logrus.Error("Invalid URL: ", url)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
+		return
+	}
+
+	opts, err := extractOptionsFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := withUpstreamTimeout(c.Request.Context())
+	defer cancel()
+	resp, err := fetch(ctx, url, opts)
+	if errors.Is(err, errBlockedByRobots) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Blocked by robots.txt", "code": "blocked_by_robots"})
+		return
+	}
+	if err != nil {
+		logrus.Error("Failed to resolve URL: ", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resolve URL"})
+		return
+	}
+	resp.Body.Close()
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":       url,
+		"final_url": resp.FinalURL,
+		"status":    resp.StatusCode,
+		"redirects": resp.Redirects,
+		"duration":  int(time.Since(startTime).Milliseconds()),
+	})
 }
@@ -0,0 +1,222 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: link2json.proto
+
+// The gRPC counterpart of the REST API. Fields mirror the /extract JSON
+// payload and query parameters.
+
+package linkpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Link2JSON_Extract_FullMethodName       = "/link2json.v1.Link2JSON/Extract"
+	Link2JSON_BatchExtract_FullMethodName  = "/link2json.v1.Link2JSON/BatchExtract"
+	Link2JSON_StreamExtract_FullMethodName = "/link2json.v1.Link2JSON/StreamExtract"
+)
+
+// Link2JSONClient is the client API for Link2JSON service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type Link2JSONClient interface {
+	// Extract returns the metadata of one URL, like GET /extract.
+	Extract(ctx context.Context, in *ExtractRequest, opts ...grpc.CallOption) (*ExtractResponse, error)
+	// BatchExtract extracts several URLs and returns once all are done.
+	BatchExtract(ctx context.Context, in *BatchExtractRequest, opts ...grpc.CallOption) (*BatchExtractResponse, error)
+	// StreamExtract sends each result of a batch as soon as it is ready, like
+	// /extract/stream.
+	StreamExtract(ctx context.Context, in *BatchExtractRequest, opts ...grpc.CallOption) (Link2JSON_StreamExtractClient, error)
+}
+
+type link2JSONClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLink2JSONClient(cc grpc.ClientConnInterface) Link2JSONClient {
+	return &link2JSONClient{cc}
+}
+
+func (c *link2JSONClient) Extract(ctx context.Context, in *ExtractRequest, opts ...grpc.CallOption) (*ExtractResponse, error) {
+	out := new(ExtractResponse)
+	err := c.cc.Invoke(ctx, Link2JSON_Extract_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *link2JSONClient) BatchExtract(ctx context.Context, in *BatchExtractRequest, opts ...grpc.CallOption) (*BatchExtractResponse, error) {
+	out := new(BatchExtractResponse)
+	err := c.cc.Invoke(ctx, Link2JSON_BatchExtract_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *link2JSONClient) StreamExtract(ctx context.Context, in *BatchExtractRequest, opts ...grpc.CallOption) (Link2JSON_StreamExtractClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Link2JSON_ServiceDesc.Streams[0], Link2JSON_StreamExtract_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &link2JSONStreamExtractClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Link2JSON_StreamExtractClient interface {
+	Recv() (*BatchItem, error)
+	grpc.ClientStream
+}
+
+type link2JSONStreamExtractClient struct {
+	grpc.ClientStream
+}
+
+func (x *link2JSONStreamExtractClient) Recv() (*BatchItem, error) {
+	m := new(BatchItem)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Link2JSONServer is the server API for Link2JSON service.
+// All implementations must embed UnimplementedLink2JSONServer
+// for forward compatibility
+type Link2JSONServer interface {
+	// Extract returns the metadata of one URL, like GET /extract.
+	Extract(context.Context, *ExtractRequest) (*ExtractResponse, error)
+	// BatchExtract extracts several URLs and returns once all are done.
+	BatchExtract(context.Context, *BatchExtractRequest) (*BatchExtractResponse, error)
+	// StreamExtract sends each result of a batch as soon as it is ready, like
+	// /extract/stream.
+	StreamExtract(*BatchExtractRequest, Link2JSON_StreamExtractServer) error
+	mustEmbedUnimplementedLink2JSONServer()
+}
+
+// UnimplementedLink2JSONServer must be embedded to have forward compatible implementations.
+type UnimplementedLink2JSONServer struct {
+}
+
+func (UnimplementedLink2JSONServer) Extract(context.Context, *ExtractRequest) (*ExtractResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Extract not implemented")
+}
+func (UnimplementedLink2JSONServer) BatchExtract(context.Context, *BatchExtractRequest) (*BatchExtractResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchExtract not implemented")
+}
+func (UnimplementedLink2JSONServer) StreamExtract(*BatchExtractRequest, Link2JSON_StreamExtractServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamExtract not implemented")
+}
+func (UnimplementedLink2JSONServer) mustEmbedUnimplementedLink2JSONServer() {}
+
+// UnsafeLink2JSONServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to Link2JSONServer will
+// result in compilation errors.
+type UnsafeLink2JSONServer interface {
+	mustEmbedUnimplementedLink2JSONServer()
+}
+
+func RegisterLink2JSONServer(s grpc.ServiceRegistrar, srv Link2JSONServer) {
+	s.RegisterService(&Link2JSON_ServiceDesc, srv)
+}
+
+func _Link2JSON_Extract_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtractRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Link2JSONServer).Extract(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Link2JSON_Extract_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Link2JSONServer).Extract(ctx, req.(*ExtractRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Link2JSON_BatchExtract_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchExtractRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Link2JSONServer).BatchExtract(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Link2JSON_BatchExtract_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Link2JSONServer).BatchExtract(ctx, req.(*BatchExtractRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Link2JSON_StreamExtract_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchExtractRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(Link2JSONServer).StreamExtract(m, &link2JSONStreamExtractServer{stream})
+}
+
+type Link2JSON_StreamExtractServer interface {
+	Send(*BatchItem) error
+	grpc.ServerStream
+}
+
+type link2JSONStreamExtractServer struct {
+	grpc.ServerStream
+}
+
+func (x *link2JSONStreamExtractServer) Send(m *BatchItem) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Link2JSON_ServiceDesc is the grpc.ServiceDesc for Link2JSON service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Link2JSON_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "link2json.v1.Link2JSON",
+	HandlerType: (*Link2JSONServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Extract",
+			Handler:    _Link2JSON_Extract_Handler,
+		},
+		{
+			MethodName: "BatchExtract",
+			Handler:    _Link2JSON_BatchExtract_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamExtract",
+			Handler:       _Link2JSON_StreamExtract_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "link2json.proto",
+}
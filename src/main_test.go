@@ -0,0 +1,30 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+var setupOnce sync.Once
+
+// setupExtraction configures the extraction pipeline from the defaults the
+// server starts with, minus the per-host delay so tests don't wait on it.
+func setupExtraction(t *testing.T) {
+	t.Helper()
+	setupOnce.Do(func() {
+		loadProfileConfig()
+		upstream := loadUpstreamConfig()
+		client, err := newUpstreamClient(upstream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpClient = client
+		maxRedirects, maxBodyBytes, totalTimeout = upstream.MaxRedirects, upstream.MaxBodyBytes, upstream.TotalTimeout
+		loadThrottleConfig()
+		hostDelay = 0
+		loadRobotsConfig()
+		loadCacheConfig()
+	})
+	extractionCache.Flush()
+	detailsCache.Flush()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"expvar"
+)
+
+// Counters published under "link2json" on /debug/vars.
+var (
+	metrics = expvar.NewMap("link2json")
+
+	cacheHits      = new(expvar.Int) // Fresh entries, including cached failures
+	cacheStaleHits = new(expvar.Int) // Stale entries served while refreshing
+	cacheMisses    = new(expvar.Int) // Lookups that had to wait for an upstream fetch
+	cacheEvictions = new(expvar.Int) // Entries dropped on expiry or by a purge
+	cachePurges    = new(expvar.Int) // Entries dropped through the admin API
+
+	upstreamRevalidations = new(expvar.Int) // Conditional refreshes sent upstream
+	upstreamNotModified   = new(expvar.Int) // Of those, how many came back 304
+)
+
+func init() {
+	metrics.Set("cache_hits", cacheHits)
+	metrics.Set("cache_stale_hits", cacheStaleHits)
+	metrics.Set("cache_misses", cacheMisses)
+	metrics.Set("cache_evictions", cacheEvictions)
+	metrics.Set("cache_purges", cachePurges)
+	metrics.Set("upstream_revalidations", upstreamRevalidations)
+	metrics.Set("upstream_not_modified", upstreamNotModified)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	URL "net/url"
+	"sort"
+	"strings"
+)
+
+// Query parameters that only carry click tracking information.
+var (
+	trackingParams = map[string]bool{
+		"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true,
+		"mc_cid": true, "mc_eid": true, "igshid": true, "_hsenc": true, "_hsmi": true,
+		"mkt_tok": true, "ref_src": true, "ref_url": true, "spm": true,
+	}
+	trackingPrefixes = []string{"utm_", "pk_", "hsa_"}
+)
+
+// normalizeURL returns a stable form of rawURL to key caches and history by:
+// lowercase scheme and host without default port, no fragment, tracking
+// parameters removed, remaining parameters sorted, and no trailing slash.
+// It is never fetched, two URLs normalizing alike only share a cache entry.
+// Anything that could change what a server returns, such as the userinfo,
+// escaped slashes or parameters separated by semicolons, is kept as is.
+func normalizeURL(rawURL string) (string, error) {
+	u, err := URL.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	host = strings.TrimSuffix(host, ".")
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	// Work on the raw query, url.Values would drop parameters containing
+	// semicolons and re-encode the rest
+	var params []string
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := URL.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if !isTrackingParam(key) {
+			params = append(params, param)
+		}
+	}
+	sort.Strings(params)
+	u.RawQuery = strings.Join(params, "&")
+	u.ForceQuery = false
+
+	path := strings.TrimSuffix(u.EscapedPath(), "/")
+	if path == "" {
+		path = "/"
+	}
+	if u.Path, err = URL.PathUnescape(path); err != nil {
+		return "", err
+	}
+	u.RawPath = path
+
+	return u.String(), nil
+}
+
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	if trackingParams[key] {
+		return true
+	}
+	for _, prefix := range trackingPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
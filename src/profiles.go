@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+const defaultBrowserUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36"
+
+// uaProfile is a named set of request headers we present to upstream sites.
+// Many sites only serve Open Graph tags to the crawlers they recognise.
+type uaProfile struct {
+	UserAgent string            `yaml:"user_agent"`
+	Headers   map[string]string `yaml:"headers"`
+}
+
+var (
+	userAgent      string // Browser profile user agent, LINK2JSON_USER_AGENT
+	defaultProfile string // Profile used when neither the request nor a rule picks one
+
+	builtinProfiles = map[string]uaProfile{
+		"bot": {
+			UserAgent: "link2json/1.0 (+https://github.com/BumpyClock/link-to-JSON)",
+		},
+		"facebookexternalhit": {
+			UserAgent: "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+		},
+		"twitterbot": {
+			UserAgent: "Twitterbot/1.0",
+		},
+	}
+)
+
+// loadProfileConfig reads the browser user agent and default profile from the environment.
+func loadProfileConfig() {
+	userAgent = os.Getenv("LINK2JSON_USER_AGENT")
+	if userAgent == "" {
+		userAgent = defaultBrowserUserAgent
+	}
+	defaultProfile = strings.ToLower(os.Getenv("LINK2JSON_UA_PROFILE"))
+	if defaultProfile == "" {
+		defaultProfile = "browser"
+	}
+}
+
+// lookupProfile finds a profile by name. Profiles defined in the rules file
+// take precedence over the built-in ones.
+func lookupProfile(name string) (uaProfile, bool) {
+	name = strings.ToLower(name)
+	if rf := rules.Load(); rf != nil {
+		if profile, ok := rf.Profiles[name]; ok {
+			return profile, true
+		}
+	}
+	if name == "browser" {
+		return uaProfile{
+			UserAgent: userAgent,
+			Headers: map[string]string{
+				"Accept": "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+			},
+		}, true
+	}
+	profile, ok := builtinProfiles[name]
+	return profile, ok
+}
+
+// setUpstreamHeaders applies the profile for host to req. The profile named
+// by the request wins over one mapped to the domain in the rules file, and
+// domain header overrides are applied last.
+func setUpstreamHeaders(req *http.Request, opts extractOptions) {
+	name := opts.Profile
+	domainRules := rules.Load().matchingRules(req.URL.Hostname())
+	if name == "" {
+		for _, dr := range domainRules {
+			if dr.Profile != "" {
+				name = dr.Profile
+			}
+		}
+	}
+	if name == "" {
+		name = defaultProfile
+	}
+
+	profile, ok := lookupProfile(name)
+	if !ok {
+		profile, _ = lookupProfile("browser")
+	}
+	req.Header.Set("User-Agent", profile.UserAgent)
+	for key, value := range profile.Headers {
+		req.Header.Set(key, value)
+	}
+	if lang := normalizeAcceptLanguage(opts.AcceptLanguage); lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+	for _, dr := range domainRules {
+		for key, value := range dr.Headers {
+			req.Header.Set(key, value)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	URL "net/url"
+	"os"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	"github.com/temoto/robotstxt"
+)
+
+const (
+	maxRobotsBytes   = 500 << 10 // Google ignores anything past 500KiB too
+	robotsFailureTTL = time.Minute
+)
+
+var (
+	respectRobots bool
+	robotsAgent   string        // Overrides the profile user agent when matching robots.txt groups
+	robotsTimeout time.Duration // Per robots.txt fetch, apart from the extraction waiting on it
+	robotsCache   *cache.Cache
+
+	errBlockedByRobots = errors.New("blocked by robots.txt")
+)
+
+// loadRobotsConfig reads the robots.txt compliance settings from the environment.
+func loadRobotsConfig() {
+	respectRobots = envBool("LINK2JSON_RESPECT_ROBOTS", false)
+	robotsAgent = os.Getenv("LINK2JSON_ROBOTS_AGENT")
+	robotsTimeout = envDuration("LINK2JSON_ROBOTS_TIMEOUT", 3*time.Second)
+	robotsCache = cache.New(envDuration("LINK2JSON_ROBOTS_TTL", time.Hour), 10*time.Minute)
+}
+
+// checkRobots returns errBlockedByRobots when compliance mode is on and the
+// robots.txt of the request's host disallows it for our user agent. Domains
+// with ignore_robots set in the rules file are exempt.
+func checkRobots(ctx context.Context, req *http.Request) error {
+	if !respectRobots {
+		return nil
+	}
+	for _, dr := range rules.Load().matchingRules(req.URL.Hostname()) {
+		if dr.IgnoreRobots {
+			return nil
+		}
+	}
+
+	agent := robotsUserAgent(req)
+	robots := robotsFor(ctx, req.URL, agent)
+	if robots != nil && !robots.TestAgent(req.URL.RequestURI(), agent) {
+		logrus.Info("Blocked by robots.txt: ", req.URL)
+		return errBlockedByRobots
+	}
+	return nil
+}
+
+// crawlDelay returns the Crawl-delay robots.txt asks of us for the request's
+// host. Unlike the Disallow rules it is honoured whether or not compliance
+// mode is on, so the host's robots.txt is fetched either way.
+func crawlDelay(ctx context.Context, req *http.Request) time.Duration {
+	agent := robotsUserAgent(req)
+	robots := robotsFor(ctx, req.URL, agent)
+	if robots == nil {
+		return 0
+	}
+	return robots.FindGroup(agent).CrawlDelay
+}
+
+// robotsUserAgent is the user agent robots.txt groups are matched against.
+func robotsUserAgent(req *http.Request) string {
+	if robotsAgent != "" {
+		return robotsAgent
+	}
+	return req.Header.Get("User-Agent")
+}
+
+// robotsFor returns the parsed robots.txt for u's host, fetching it on a
+// cache miss. The fetch gets robotsTimeout whatever time the extraction has
+// left, and a robots.txt that can't be fetched or parsed is treated as
+// absent for robotsFailureTTL, so a host with a broken one isn't asked
+// again on every extraction.
+func robotsFor(ctx context.Context, u *URL.URL, agent string) *robotstxt.RobotsData {
+	origin := u.Scheme + "://" + u.Host
+	if cached, found := robotsCache.Get(origin); found {
+		return cached.(*robotstxt.RobotsData)
+	}
+
+	robots, err := fetchRobots(ctx, origin, agent)
+	if err != nil {
+		logrus.Debug("Failed to fetch robots.txt for ", origin, ": ", err)
+		robots, _ = robotstxt.FromStatusAndBytes(http.StatusNotFound, nil)
+		robotsCache.Set(origin, robots, robotsFailureTTL)
+		return robots
+	}
+	robotsCache.SetDefault(origin, robots)
+	return robots
+}
+
+func fetchRobots(ctx context.Context, origin, agent string) (*robotstxt.RobotsData, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), robotsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", agent)
+
+	// Unlike pages, robots.txt redirects are simply followed
+	client := *httpClient
+	client.CheckRedirect = nil
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsBytes))
+	if err != nil {
+		return nil, err
+	}
+	return robotstxt.FromStatusAndBytes(resp.StatusCode, body)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHangingRobotsDoesNotBlockExtraction(t *testing.T) {
+	setupExtraction(t)
+	defer func(d time.Duration) { robotsTimeout = d }(robotsTimeout)
+	robotsTimeout = 100 * time.Millisecond
+
+	var robotsFetches atomic.Int32
+	hang := make(chan struct{})
+	defer close(hang)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches.Add(1)
+			select {
+			case <-hang:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprint(w, `<html><head><title>Reachable</title></head></html>`)
+	}))
+	defer upstream.Close()
+
+	for _, path := range []string{"/a", "/b"} {
+		result, _, err := cachedMetadata(context.Background(), upstream.URL+path, extractOptions{})
+		if err != nil {
+			t.Fatalf("extracting %s: %v", path, err)
+		}
+		if result.Metadata.Title != "Reachable" {
+			t.Errorf("title %q", result.Metadata.Title)
+		}
+	}
+	if n := robotsFetches.Load(); n != 1 {
+		t.Errorf("fetched robots.txt %d times, want the failure cached after one", n)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	URL "net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	link2json "github.com/BumpyClock/go-link2json"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const sourceRule = "rule"
+
+// ruleFile is the on-disk layout of the rules file.
+//
+//	profiles:
+//	  partner:
+//	    user_agent: PartnerBot/2.0
+//	rules:
+//	  - domain: example.com
+//	    profile: facebookexternalhit
+//	    headers:
+//	      Cookie: consent=1
+//	    fields:
+//	      title:
+//	        selector: h1.headline
+//	        replace:
+//	          - pattern: '\s*\|\s*Example$'
+//	            with: ''
+type ruleFile struct {
+	Profiles map[string]uaProfile `yaml:"profiles"`
+	Rules    []domainRule         `yaml:"rules"`
+}
+
+// domainRule holds the fetch settings and field rules for a domain and its subdomains.
+type domainRule struct {
+	Domain  string               `yaml:"domain"`
+	Profile string               `yaml:"profile"` // User agent profile to fetch with
+	Headers map[string]string    `yaml:"headers"` // Applied on top of the profile
+	Fields  map[string]fieldRule `yaml:"fields"`
+
+	IgnoreRobots bool `yaml:"ignore_robots"` // Exempt from robots.txt compliance mode
+}
+
+// fieldRule rewrites one metadata field. Value, Selector and XPath are
+// alternatives tried in that order; Replace runs on whatever value results.
+type fieldRule struct {
+	Value    string        `yaml:"value"`
+	Selector string        `yaml:"selector"`
+	XPath    string        `yaml:"xpath"`
+	Attr     string        `yaml:"attr"` // Empty reads the element text
+	Replace  []replaceRule `yaml:"replace"`
+
+	selector goquery.Matcher
+	xpath    *xpath.Expr
+}
+
+type replaceRule struct {
+	Pattern string `yaml:"pattern"`
+	With    string `yaml:"with"`
+
+	re *regexp.Regexp
+}
+
+// ruleEffect describes a single change a rule made to a field.
+type ruleEffect struct {
+	Domain string `json:"domain"`
+	Field  string `json:"field"`
+	Rule   string `json:"rule"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+var (
+	rules atomic.Pointer[ruleFile]
+
+	ruleFields = map[string]bool{"title": true, "description": true, "sitename": true, "favicon": true, "image": true}
+)
+
+// loadRules parses and validates the rules file at path.
+func loadRules(path string) (*ruleFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rf ruleFile
+	if err := yaml.Unmarshal(data, &rf); err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]uaProfile, len(rf.Profiles))
+	for name, profile := range rf.Profiles {
+		if profile.UserAgent == "" {
+			return nil, fmt.Errorf("profile %s has no user_agent", name)
+		}
+		profiles[strings.ToLower(name)] = profile
+	}
+	rf.Profiles = profiles
+
+	for i := range rf.Rules {
+		dr := &rf.Rules[i]
+		dr.Domain = strings.ToLower(strings.TrimPrefix(dr.Domain, "www."))
+		if dr.Domain == "" {
+			return nil, fmt.Errorf("rule %d has no domain", i)
+		}
+		if dr.Profile != "" {
+			dr.Profile = strings.ToLower(dr.Profile)
+			if _, ok := rf.Profiles[dr.Profile]; !ok {
+				if _, ok := lookupProfile(dr.Profile); !ok {
+					return nil, fmt.Errorf("rule for %s: unknown profile %q", dr.Domain, dr.Profile)
+				}
+			}
+		}
+		for field, fr := range dr.Fields {
+			if !ruleFields[field] {
+				return nil, fmt.Errorf("rule for %s: unknown field %q", dr.Domain, field)
+			}
+			if fr.Selector != "" {
+				sel, err := cascadia.Compile(fr.Selector)
+				if err != nil {
+					return nil, fmt.Errorf("rule for %s: field %s: invalid selector: %w", dr.Domain, field, err)
+				}
+				fr.selector = sel
+			}
+			if fr.XPath != "" {
+				expr, err := xpath.Compile(fr.XPath)
+				if err != nil {
+					return nil, fmt.Errorf("rule for %s: field %s: invalid xpath: %w", dr.Domain, field, err)
+				}
+				fr.xpath = expr
+			}
+			for j := range fr.Replace {
+				re, err := regexp.Compile(fr.Replace[j].Pattern)
+				if err != nil {
+					return nil, fmt.Errorf("rule for %s: field %s: %w", dr.Domain, field, err)
+				}
+				fr.Replace[j].re = re
+			}
+			dr.Fields[field] = fr
+		}
+	}
+	return &rf, nil
+}
+
+// watchRules loads the rules file and reloads it whenever its modification
+// time changes. A file that fails to parse leaves the previous rules in place.
+// Cached extractions carry the output of the rules they ran under, so they
+// are flushed whenever the rules change.
+func watchRules(path string, interval time.Duration) {
+	var modTime time.Time
+	for {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			if rules.Swap(nil) != nil {
+				logrus.Warn("Rules file removed, clearing rules: ", path)
+				extractionCache.Flush()
+			}
+			modTime = time.Time{}
+		case !info.ModTime().Equal(modTime):
+			modTime = info.ModTime()
+			rf, err := loadRules(path)
+			if err != nil {
+				logrus.Error("Failed to load rules file: ", err)
+				break
+			}
+			if rules.Swap(rf) != nil {
+				extractionCache.Flush()
+			}
+			logrus.Info("Loaded ", len(rf.Rules), " extraction rules from ", path)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// matchingRules returns the rules whose domain matches host, least specific first
+// so that subdomain rules get the last word.
+func (rf *ruleFile) matchingRules(host string) []domainRule {
+	if rf == nil {
+		return nil
+	}
+	host = strings.ToLower(strings.TrimPrefix(host, "www."))
+	var matched []domainRule
+	for _, dr := range rf.Rules {
+		if host == dr.Domain || strings.HasSuffix(host, "."+dr.Domain) {
+			matched = append(matched, dr)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return len(matched[i].Domain) < len(matched[j].Domain)
+	})
+	return matched
+}
+
+// applyRules runs the current domain rules over result and returns what
+// changed. Rules are matched on the host the page was served from, so they
+// also apply to pages reached through redirects.
+func applyRules(result *extraction) []ruleEffect {
+	var effects []ruleEffect
+	for _, dr := range rules.Load().matchingRules(resultHost(result)) {
+		fields := make([]string, 0, len(dr.Fields))
+		for field := range dr.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		for _, field := range fields {
+			fr := dr.Fields[field]
+			before := fieldValue(result, field)
+			after, how := fr.apply(result, before)
+			if after == before {
+				continue
+			}
+			setFieldValue(result, field, after)
+			after = fieldValue(result, field)
+			sourceField := field
+			if field == "image" {
+				sourceField = "images"
+			}
+			result.Sources[sourceField] = fieldSource{Source: sourceRule, Selector: dr.Domain + ": " + how, Confidence: 1}
+			effects = append(effects, ruleEffect{Domain: dr.Domain, Field: field, Rule: how, Before: before, After: after})
+		}
+	}
+	return effects
+}
+
+// resultHost returns the host result was served from.
+func resultHost(result *extraction) string {
+	url := result.FinalURL
+	if url == "" {
+		url = result.Metadata.URL
+	}
+	parsed, err := URL.Parse(url)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
+// apply returns the rewritten value and a short description of what produced it.
+func (fr fieldRule) apply(result *extraction, value string) (string, string) {
+	var how []string
+	switch {
+	case fr.Value != "":
+		value = fr.Value
+		how = append(how, "value")
+	case fr.selector != nil && result.doc != nil:
+		sel := result.doc.FindMatcher(fr.selector).First()
+		if sel.Length() > 0 {
+			if fr.Attr == "" {
+				value = strings.TrimSpace(sel.Text())
+			} else {
+				value = strings.TrimSpace(sel.AttrOr(fr.Attr, ""))
+			}
+			how = append(how, "selector "+fr.Selector)
+		}
+	case fr.xpath != nil && result.doc != nil && len(result.doc.Nodes) > 0:
+		if node := htmlquery.QuerySelector(result.doc.Nodes[0], fr.xpath); node != nil {
+			if fr.Attr == "" {
+				value = strings.TrimSpace(htmlquery.InnerText(node))
+			} else {
+				value = strings.TrimSpace(htmlquery.SelectAttr(node, fr.Attr))
+			}
+			how = append(how, "xpath "+fr.XPath)
+		}
+	}
+	for _, r := range fr.Replace {
+		if r.re.MatchString(value) {
+			value = r.re.ReplaceAllString(value, r.With)
+			how = append(how, "replace "+r.Pattern)
+		}
+	}
+	return value, strings.Join(how, ", ")
+}
+
+func fieldValue(result *extraction, field string) string {
+	m := result.Metadata
+	switch field {
+	case "title":
+		return m.Title
+	case "description":
+		return m.Description
+	case "sitename":
+		return m.Sitename
+	case "favicon":
+		return m.Favicon
+	case "image":
+		if len(m.Images) > 0 {
+			return m.Images[0].URL
+		}
+	}
+	return ""
+}
+
+func setFieldValue(result *extraction, field, value string) {
+	m := result.Metadata
+	switch field {
+	case "title":
+		m.Title = value
+	case "description":
+		m.Description = value
+	case "sitename":
+		m.Sitename = value
+	case "favicon":
+		m.Favicon = resolveReference(result.FinalURL, value)
+	case "image":
+		if len(m.Images) == 0 {
+			m.Images = append(m.Images, link2json.WebImage{})
+		}
+		m.Images[0].URL = resolveReference(result.FinalURL, value)
+	}
+}
+
+// rulesTestHandler extracts a URL and reports which rules matched and what they changed.
+func rulesTestHandler(c *gin.Context) {
+	if !rateLimiter.Allow() {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		return
+	}
+
+	url := c.Query("url")
+	if url == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
+		return
+	}
+	if _, err := URL.ParseRequestURI(url); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
+		return
+	}
+	opts, err := extractOptionsFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Bypass the cache so the current rules are always the ones shown
+	ctx, cancel := withUpstreamTimeout(c.Request.Context())
+	defer cancel()
+	result, err := getMetadata(ctx, url, opts)
+	if err != nil {
+		respondFetchError(c, url, err)
+		return
+	}
+
+	var domains []string
+	for _, dr := range rules.Load().matchingRules(resultHost(result)) {
+		domains = append(domains, dr.Domain)
+	}
+	effects := result.RuleEffects
+	if effects == nil {
+		effects = []ruleEffect{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":      url,
+		"rules":    domains,
+		"effects":  effects,
+		"metadata": result.Metadata,
+	})
+}
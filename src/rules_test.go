@@ -0,0 +1,204 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	link2json "github.com/BumpyClock/go-link2json"
+	"github.com/PuerkitoBio/goquery"
+)
+
+const rulesTestPage = `<html><head>
+<title>Headline | Example</title>
+<meta name="thumbnail" content="/thumb.png">
+</head><body>
+<h1 class="headline" data-short="Short">  Real Headline  </h1>
+<p class="dek">Dek text - Example News</p>
+</body></html>`
+
+func TestLoadRulesValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"unknown field", "rules:\n  - domain: example.com\n    fields:\n      headline:\n        value: x\n", `unknown field "headline"`},
+		{"bad selector", "rules:\n  - domain: example.com\n    fields:\n      title:\n        selector: 'h1[['\n", "invalid selector"},
+		{"bad xpath", "rules:\n  - domain: example.com\n    fields:\n      title:\n        xpath: '//h1['\n", "invalid xpath"},
+		{"bad regex", "rules:\n  - domain: example.com\n    fields:\n      title:\n        replace:\n          - pattern: '(unclosed'\n", "missing closing )"},
+		{"unknown profile", "rules:\n  - domain: example.com\n    profile: nosuchbot\n", `unknown profile "nosuchbot"`},
+		{"profile without user agent", "profiles:\n  partner: {}\n", "profile partner has no user_agent"},
+		{"no domain", "rules:\n  - profile: bot\n", "rule 0 has no domain"},
+		{"profile from the file", "profiles:\n  partner:\n    user_agent: PartnerBot/2.0\nrules:\n  - domain: example.com\n    profile: Partner\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadRules(writeRules(t, tt.yaml))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		url     string
+		want    map[string]string
+		effects []ruleEffect
+	}{
+		{
+			name: "subdomain rule has the last word",
+			yaml: `rules:
+  - domain: news.example.com
+    fields:
+      title:
+        selector: h1.headline
+  - domain: www.example.com
+    fields:
+      title:
+        value: Domain Title
+`,
+			url:  "https://news.example.com/a",
+			want: map[string]string{"title": "Real Headline"},
+			effects: []ruleEffect{
+				{Domain: "example.com", Field: "title", Rule: "value", Before: "Headline | Example", After: "Domain Title"},
+				{Domain: "news.example.com", Field: "title", Rule: "selector h1.headline", Before: "Domain Title", After: "Real Headline"},
+			},
+		},
+		{
+			name: "parent domain only",
+			yaml: `rules:
+  - domain: news.example.com
+    fields:
+      title:
+        selector: h1.headline
+  - domain: example.com
+    fields:
+      title:
+        value: Domain Title
+`,
+			url:  "https://www.example.com/a",
+			want: map[string]string{"title": "Domain Title"},
+			effects: []ruleEffect{
+				{Domain: "example.com", Field: "title", Rule: "value", Before: "Headline | Example", After: "Domain Title"},
+			},
+		},
+		{
+			name: "other domains untouched",
+			yaml: `rules:
+  - domain: example.com
+    fields:
+      title:
+        value: Domain Title
+`,
+			url:  "https://notexample.com/a",
+			want: map[string]string{"title": "Headline | Example"},
+		},
+		{
+			name: "xpath and attr reads",
+			yaml: `rules:
+  - domain: example.com
+    fields:
+      image:
+        xpath: //meta[@name='thumbnail']
+        attr: content
+      sitename:
+        selector: h1
+        attr: data-short
+      description:
+        xpath: //p[@class='dek']
+`,
+			url:  "https://example.com/a",
+			want: map[string]string{"image": "https://example.com/thumb.png", "sitename": "Short", "description": "Dek text - Example News"},
+			effects: []ruleEffect{
+				{Domain: "example.com", Field: "description", Rule: "xpath //p[@class='dek']", Before: "", After: "Dek text - Example News"},
+				{Domain: "example.com", Field: "image", Rule: "xpath //meta[@name='thumbnail']", Before: "", After: "https://example.com/thumb.png"},
+				{Domain: "example.com", Field: "sitename", Rule: "selector h1", Before: "", After: "Short"},
+			},
+		},
+		{
+			name: "replace chain",
+			yaml: `rules:
+  - domain: example.com
+    fields:
+      title:
+        replace:
+          - pattern: '\s*\|\s*Example$'
+            with: ''
+          - pattern: '^Headline$'
+            with: 'Top Story'
+          - pattern: 'never matches'
+            with: 'x'
+`,
+			url:  "https://example.com/a",
+			want: map[string]string{"title": "Top Story"},
+			effects: []ruleEffect{
+				{Domain: "example.com", Field: "title", Rule: `replace \s*\|\s*Example$, replace ^Headline$`, Before: "Headline | Example", After: "Top Story"},
+			},
+		},
+	}
+	defer rules.Store(rules.Load())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf, err := loadRules(writeRules(t, tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules.Store(rf)
+
+			result := rulesTestExtraction(t, tt.url)
+			effects := applyRules(result)
+			for field, want := range tt.want {
+				if got := fieldValue(result, field); got != want {
+					t.Errorf("%s = %q, want %q", field, got, want)
+				}
+			}
+			if !reflect.DeepEqual(effects, tt.effects) {
+				t.Errorf("effects\n got %+v\nwant %+v", effects, tt.effects)
+			}
+			for _, e := range effects {
+				field := e.Field
+				if field == "image" {
+					field = "images"
+				}
+				if result.Sources[field].Source != sourceRule {
+					t.Errorf("source of %s is %+v", field, result.Sources[field])
+				}
+			}
+		})
+	}
+}
+
+// writeRules writes a rules file to a temporary directory and returns its path.
+func writeRules(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rulesTestExtraction returns an extraction of rulesTestPage as served from url.
+func rulesTestExtraction(t *testing.T, url string) *extraction {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(rulesTestPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &extraction{
+		Metadata: &link2json.MetaDataResponseItem{URL: url, Title: "Headline | Example"},
+		FinalURL: url,
+		Sources:  map[string]fieldSource{},
+		doc:      doc,
+	}
+}
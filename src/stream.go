@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+const maxStreamURLs = 100
+
+// batchLimit is the most URLs one batch may ask for, no more than the rate
+// limiter could ever let through at once.
+func batchLimit() int {
+	if rateLimiter.Limit() == rate.Inf {
+		return maxStreamURLs
+	}
+	return min(maxStreamURLs, rateLimiter.Burst())
+}
+
+// streamResult is the data of a "result" event.
+type streamResult struct {
+	Index int `json:"index"` // Position of the URL in the request
+	batchResult
+}
+
+// streamProgress is the data of a "progress" event, sent after every result.
+type streamProgress struct {
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+}
+
+// streamSummary is the data of the final "summary" event.
+type streamSummary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Duration  int `json:"duration"` // Milliseconds
+}
+
+// extractStreamHandler extracts several URLs and sends each result as a
+// Server-Sent Event as soon as it is ready, rather than waiting for the
+// slowest. GET takes repeated url parameters, POST a JSON body with a urls
+// list. The other /extract parameters apply to every URL.
+func extractStreamHandler(c *gin.Context) {
+	startTime := time.Now()
+	urls := c.QueryArray("url")
+	if c.Request.Method == http.MethodPost {
+		var req struct {
+			URLs []string `json:"urls"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A JSON body with a urls list is required"})
+			return
+		}
+		urls = req.URLs
+	}
+	if len(urls) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
+		return
+	}
+	if limit := batchLimit(); len(urls) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many URLs, the limit is %d", limit)})
+		return
+	}
+	// Every URL is an extraction, so every URL takes a token
+	if !rateLimiter.AllowN(time.Now(), len(urls)) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		return
+	}
+
+	opts, err := extractOptionsFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Extractions stop when the client goes away, the request context is
+	// cancelled then
+	ctx := c.Request.Context()
+	results := streamBatch(ctx, urls, opts)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Keep nginx from holding events back
+	c.Status(http.StatusOK)
+
+	summary := streamSummary{Total: len(urls)}
+	for result := range results {
+		if result.Status == http.StatusOK {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+		c.SSEvent("result", result)
+		c.SSEvent("progress", streamProgress{Completed: summary.Succeeded + summary.Failed, Total: len(urls)})
+		c.Writer.Flush()
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	summary.Duration = int(time.Since(startTime).Milliseconds())
+	c.SSEvent("summary", summary)
+	c.Writer.Flush()
+}
+
+// streamBatch extracts urls a few at a time and sends each result on the
+// returned channel as it finishes. The channel is closed once all are done,
+// or early if ctx is cancelled.
+func streamBatch(ctx context.Context, urls []string, opts extractOptions) <-chan streamResult {
+	results := make(chan streamResult)
+	sem := make(chan struct{}, jobURLConcurrency)
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			result := streamResult{Index: i, batchResult: extractBatchURL(ctx, url, opts)}
+			select {
+			case results <- result:
+			case <-ctx.Done():
+			}
+		}(i, url)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+func TestStreamChargesPerURL(t *testing.T) {
+	setupExtraction(t)
+	defer func(l *rate.Limiter) { rateLimiter = l }(rateLimiter)
+	rateLimiter = rate.NewLimiter(rate.Every(time.Hour), 3)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Streamed</title></head></html>`)
+	}))
+	defer upstream.Close()
+
+	for _, tt := range []struct {
+		urls int
+		want int
+	}{
+		{4, http.StatusBadRequest}, // More than the limiter could ever allow
+		{2, http.StatusOK},
+		{2, http.StatusTooManyRequests},
+		{1, http.StatusOK},
+	} {
+		query := make([]string, tt.urls)
+		for i := range query {
+			query[i] = fmt.Sprintf("url=%s/%d", upstream.URL, i)
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/extract/stream?"+strings.Join(query, "&"), nil)
+		extractStreamHandler(c)
+		if w.Code != tt.want {
+			t.Errorf("streaming %d URLs answered %d, want %d", tt.urls, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	hostConcurrency int           // Simultaneous fetches allowed per upstream host
+	hostDelay       time.Duration // Minimum gap between starting fetches to the same host
+	maxHostDelay    time.Duration // Cap on Crawl-delay and Retry-After so one site can't stall us forever
+
+	hostThrottles   *cache.Cache // Host to *hostThrottle, dropped once a host goes quiet
+	hostThrottlesMu sync.Mutex
+)
+
+// hostThrottle paces the fetches we make to a single upstream host.
+type hostThrottle struct {
+	slots chan struct{}
+
+	mu   sync.Mutex
+	next time.Time // Earliest time the next fetch may start
+}
+
+// loadThrottleConfig reads the per-host politeness settings from the environment.
+func loadThrottleConfig() {
+	hostConcurrency = envInt("LINK2JSON_HOST_CONCURRENCY", 2)
+	if hostConcurrency < 1 {
+		hostConcurrency = 1
+	}
+	hostDelay = envDuration("LINK2JSON_HOST_DELAY", 250*time.Millisecond)
+	maxHostDelay = envDuration("LINK2JSON_MAX_HOST_DELAY", 30*time.Second)
+	hostThrottles = cache.New(10*time.Minute, 10*time.Minute)
+}
+
+func throttleFor(host string) *hostThrottle {
+	hostThrottlesMu.Lock()
+	defer hostThrottlesMu.Unlock()
+
+	t, found := hostThrottles.Get(host)
+	if !found {
+		t = &hostThrottle{slots: make(chan struct{}, hostConcurrency)}
+	}
+	hostThrottles.SetDefault(host, t) // Keep busy hosts from expiring
+	return t.(*hostThrottle)
+}
+
+// acquireHost waits for a free fetch slot for host and for its delay since
+// the previous fetch to pass. Callers must call release once the response
+// has been read.
+func acquireHost(ctx context.Context, host string, delay time.Duration) (func(), error) {
+	t := throttleFor(host)
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-t.slots }
+
+	if delay < hostDelay {
+		delay = hostDelay
+	}
+	delay = min(delay, maxHostDelay)
+
+	t.mu.Lock()
+	start := time.Now()
+	if t.next.After(start) {
+		start = t.next
+	}
+	reserved := start.Add(delay)
+	t.next = reserved
+	t.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		logrus.Debug("Waiting ", wait, " before fetching from ", host)
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			// Hand the turn back unless someone has queued up behind it since
+			t.mu.Lock()
+			if t.next.Equal(reserved) {
+				t.next = start
+			}
+			t.mu.Unlock()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// backoffHost holds off further fetches to host until the time the upstream
+// asked for in a Retry-After header. It returns how long that is.
+func backoffHost(host string, resp *http.Response) time.Duration {
+	wait := retryAfter(resp.Header.Get("Retry-After"))
+	if wait <= 0 {
+		wait = hostDelay
+	}
+	wait = min(wait, maxHostDelay)
+
+	t := throttleFor(host)
+	t.mu.Lock()
+	if until := time.Now().Add(wait); until.After(t.next) {
+		t.next = until
+	}
+	t.mu.Unlock()
+	logrus.Info("Upstream ", host, " asked us to back off for ", wait)
+	return wait
+}
+
+// retryAfter parses a Retry-After value given in seconds or as an HTTP date.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueuedFetchesDontTimeOut(t *testing.T) {
+	setupExtraction(t)
+	defer func(delay, timeout time.Duration) { hostDelay, totalTimeout = delay, timeout }(hostDelay, totalTimeout)
+	// Six fetches 100ms apart queue for longer than the timeout of each
+	hostDelay, totalTimeout = 100*time.Millisecond, 300*time.Millisecond
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Queued</title></head></html>`)
+	}))
+	defer upstream.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for i := range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := cachedMetadata(context.Background(), fmt.Sprintf("%s/%d", upstream.URL, i), extractOptions{})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestUpstreamClockStartsWithFetch(t *testing.T) {
+	defer func(timeout time.Duration) { totalTimeout = timeout }(totalTimeout)
+	totalTimeout = 50 * time.Millisecond
+
+	ctx, cancel := withUpstreamTimeout(context.Background())
+	defer cancel()
+	time.Sleep(100 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("timed out before any fetch started")
+	}
+	startUpstreamClock(ctx)
+	<-ctx.Done()
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", ctx.Err())
+	}
+}
+
+func TestAbandonedWaitGivesBackItsTurn(t *testing.T) {
+	setupExtraction(t)
+	host := "abandoned.example"
+	release, err := acquireHost(context.Background(), host, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := acquireHost(ctx, host, time.Second); err == nil {
+		t.Fatal("acquired a turn a second early")
+	}
+
+	// Only the first fetch's delay should remain, not the abandoned one's too
+	start := time.Now()
+	release, err = acquireHost(context.Background(), host, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if waited := time.Since(start); waited > 1500*time.Millisecond {
+		t.Errorf("waited %v behind an abandoned turn", waited)
+	}
+}
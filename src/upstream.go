@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	URL "net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// upstreamConfig controls how we talk to the sites we extract metadata from.
+type upstreamConfig struct {
+	ConnectTimeout time.Duration
+	TLSTimeout     time.Duration
+	HeaderTimeout  time.Duration
+	TotalTimeout   time.Duration // Per extraction, across redirects and follow-up fetches
+	MaxBodyBytes   int64
+	MaxRedirects   int
+	Proxy          string // http://, https:// or socks5:// URL, empty uses the environment
+	CABundle       string // PEM file trusted in addition to the system roots
+}
+
+// loadUpstreamConfig reads the upstream client settings from the environment.
+func loadUpstreamConfig() upstreamConfig {
+	return upstreamConfig{
+		ConnectTimeout: envDuration("LINK2JSON_CONNECT_TIMEOUT", 5*time.Second),
+		TLSTimeout:     envDuration("LINK2JSON_TLS_TIMEOUT", 5*time.Second),
+		HeaderTimeout:  envDuration("LINK2JSON_HEADER_TIMEOUT", 10*time.Second),
+		TotalTimeout:   envDuration("LINK2JSON_UPSTREAM_TIMEOUT", 10*time.Second), // Same default timeout colly uses
+		MaxBodyBytes:   int64(envInt("LINK2JSON_MAX_BODY_BYTES", 10<<20)),
+		MaxRedirects:   envInt("LINK2JSON_MAX_REDIRECTS", 10),
+		Proxy:          os.Getenv("LINK2JSON_PROXY"),
+		CABundle:       os.Getenv("LINK2JSON_CA_BUNDLE"),
+	}
+}
+
+// newUpstreamClient builds the client used for every upstream fetch. It never
+// follows redirects itself, fetch does that so each hop can be recorded.
+func newUpstreamClient(cfg upstreamConfig) (*http.Client, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   cfg.TLSTimeout,
+		ResponseHeaderTimeout: cfg.HeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		ForceAttemptHTTP2:     true,
+	}
+
+	if cfg.Proxy != "" {
+		proxyURL, err := URL.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if cfg.CABundle != "" {
+		pem, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	// No client Timeout, it would apply to each redirect hop on its own.
+	// TotalTimeout bounds whole extractions instead, see withUpstreamTimeout.
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// limitedBody caps how much of a response body is read, silently truncating
+// the rest. Truncated HTML still parses, and the head is what we care about.
+// Closing it hands the host's fetch slot back.
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	checked   bool
+	truncated bool
+	release   func()
+	once      sync.Once
+}
+
+func limitBody(body io.ReadCloser, limit int64, release func()) io.ReadCloser {
+	return &limitedBody{body: body, remaining: limit, release: release}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// One byte past the limit tells a body of exactly the limit from a longer one
+		if !b.checked {
+			b.checked = true
+			var extra [1]byte
+			n, _ := io.ReadFull(b.body, extra[:])
+			b.truncated = n > 0
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// bodyTruncated reports whether body was cut short by limitBody. It is only
+// known once the body has been read up to the limit.
+func bodyTruncated(body io.Reader) bool {
+	b, ok := body.(*limitedBody)
+	return ok && b.truncated
+}
+
+func (b *limitedBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(b.release)
+	return err
+}
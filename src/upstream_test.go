@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLimitBodyTruncation(t *testing.T) {
+	tests := []struct {
+		body      string
+		limit     int64
+		want      string
+		truncated bool
+	}{
+		{"short", 10, "short", false},
+		{"exactly10!", 10, "exactly10!", false},
+		{"longer than ten", 10, "longer tha", true},
+	}
+	for _, tt := range tests {
+		body := limitBody(io.NopCloser(strings.NewReader(tt.body)), tt.limit, func() {})
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("read %q from %q, want %q", data, tt.body, tt.want)
+		}
+		if got := bodyTruncated(body); got != tt.truncated {
+			t.Errorf("bodyTruncated for %q = %v, want %v", tt.body, got, tt.truncated)
+		}
+		body.Close()
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const watchConcurrency = 4 // Watches re-extracted at once
+
+var (
+	minWatchInterval time.Duration // Shortest re-extraction interval a watch may ask for
+	watchPoll        time.Duration // How often due watches are looked for
+	maxWatches       int           // Watches beyond this are turned away
+)
+
+const watchSchema = `
+CREATE TABLE IF NOT EXISTS watches (
+	url          TEXT    PRIMARY KEY,
+	interval_ms  INTEGER NOT NULL,
+	created_at   INTEGER NOT NULL, -- Unix milliseconds, as are the other times
+	next_run     INTEGER NOT NULL,
+	last_run     INTEGER,
+	last_error   TEXT,
+	last_seen    TEXT,             -- JSON of the watchedFields seen on the last successful run
+	callback_url TEXT    NOT NULL DEFAULT '',
+	secret       TEXT    NOT NULL DEFAULT ''
+);
+`
+
+// watch is a URL re-extracted on a schedule so its snapshots can be diffed,
+// optionally notifying a webhook when what a link preview shows changes.
+type watch struct {
+	URL         string     `json:"url"`
+	Interval    string     `json:"interval"`
+	CallbackURL string     `json:"callback_url,omitempty"`
+	Signed      bool       `json:"signed"` // Whether callbacks carry an HMAC signature, the secret itself is never shown
+	CreatedAt   time.Time  `json:"created_at"`
+	NextRun     time.Time  `json:"next_run"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// loadWatchConfig reads the watch scheduling settings from the environment.
+func loadWatchConfig() {
+	minWatchInterval = envDuration("LINK2JSON_MIN_WATCH_INTERVAL", 5*time.Minute)
+	watchPoll = envDuration("LINK2JSON_WATCH_POLL", 30*time.Second)
+	maxWatches = envInt("LINK2JSON_MAX_WATCHES", 1000)
+}
+
+// createWatchHandler adds a URL to the watch list, or changes the interval
+// and callback of one already on it.
+func createWatchHandler(c *gin.Context) {
+	if historyDB == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "History is disabled"})
+		return
+	}
+	var req struct {
+		URL         string `json:"url"`
+		Interval    string `json:"interval"`     // Such as 1h, defaults to the minimum
+		CallbackURL string `json:"callback_url"` // Optional webhook for changes
+		Secret      string `json:"secret"`       // Optional HMAC key for signing callbacks
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A JSON body with a url is required"})
+		return
+	}
+	url := req.URL
+	if _, err := validateURL(url); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
+		return
+	}
+	interval := minWatchInterval
+	var err error
+	if req.Interval != "" {
+		interval, err = time.ParseDuration(req.Interval)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interval"})
+			return
+		}
+		if interval < minWatchInterval {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Interval must be at least " + minWatchInterval.String()})
+			return
+		}
+	}
+
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(c.Request.Context(), req.CallbackURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callback URL: " + err.Error()})
+			return
+		}
+	}
+
+	// Changing a watch already on the list doesn't count against the limit
+	var others int
+	if err := historyDB.QueryRowContext(c.Request.Context(), "SELECT COUNT(*) FROM watches WHERE url != ?", url).Scan(&others); err != nil {
+		logrus.Error("Failed to count watches: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save watch"})
+		return
+	}
+	if others >= maxWatches {
+		c.JSON(http.StatusConflict, gin.H{"error": "Too many watches, the limit is " + strconv.Itoa(maxWatches)})
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	_, err = historyDB.ExecContext(c.Request.Context(),
+		`INSERT INTO watches (url, interval_ms, created_at, next_run, callback_url, secret) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (url) DO UPDATE SET interval_ms = excluded.interval_ms,
+			callback_url = excluded.callback_url, secret = excluded.secret`,
+		url, interval.Milliseconds(), now, now, req.CallbackURL, req.Secret)
+	if err != nil {
+		logrus.Error("Failed to save watch: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save watch"})
+		return
+	}
+	logrus.Info("Watching ", url, " every ", interval)
+	c.JSON(http.StatusCreated, gin.H{"url": url, "interval": interval.String(), "callback_url": req.CallbackURL, "signed": req.Secret != ""})
+}
+
+// listWatchesHandler returns the watch list.
+func listWatchesHandler(c *gin.Context) {
+	if historyDB == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "History is disabled"})
+		return
+	}
+	rows, err := historyDB.QueryContext(c.Request.Context(),
+		"SELECT url, interval_ms, created_at, next_run, last_run, last_error, callback_url, secret != '' FROM watches ORDER BY created_at")
+	if err != nil {
+		logrus.Error("Failed to query watches: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query watches"})
+		return
+	}
+	defer rows.Close()
+
+	watches := []watch{}
+	for rows.Next() {
+		var w watch
+		var interval, created, next int64
+		var last *int64
+		var lastError *string
+		if err := rows.Scan(&w.URL, &interval, &created, &next, &last, &lastError, &w.CallbackURL, &w.Signed); err != nil {
+			logrus.Error("Failed to read watches: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query watches"})
+			return
+		}
+		w.Interval = (time.Duration(interval) * time.Millisecond).String()
+		w.CreatedAt, w.NextRun = time.UnixMilli(created).UTC(), time.UnixMilli(next).UTC()
+		if last != nil {
+			t := time.UnixMilli(*last).UTC()
+			w.LastRun = &t
+		}
+		if lastError != nil {
+			w.LastError = *lastError
+		}
+		watches = append(watches, w)
+	}
+	c.JSON(http.StatusOK, gin.H{"watches": watches})
+}
+
+// deleteWatchHandler takes a URL off the watch list. Its snapshots are kept.
+func deleteWatchHandler(c *gin.Context) {
+	if historyDB == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "History is disabled"})
+		return
+	}
+	url, ok := queryURL(c)
+	if !ok {
+		return
+	}
+	res, err := historyDB.ExecContext(c.Request.Context(), "DELETE FROM watches WHERE url = ?", url)
+	if err != nil {
+		logrus.Error("Failed to delete watch: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete watch"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not watched"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// runWatches re-extracts due watches until the process exits. Each run goes
+// through the cache so it refreshes what /extract serves, and a successful
+// one adds a snapshot to diff against.
+func runWatches() {
+	ticker := time.NewTicker(watchPoll)
+	defer ticker.Stop()
+	for {
+		runDueWatches()
+		<-ticker.C
+	}
+}
+
+func runDueWatches() {
+	now := time.Now().UnixMilli()
+	rows, err := historyDB.Query("SELECT url, interval_ms, last_seen, callback_url, secret FROM watches WHERE next_run <= ?", now)
+	if err != nil {
+		logrus.Error("Failed to query watches: ", err)
+		return
+	}
+	var watches []dueWatch
+	for rows.Next() {
+		var w dueWatch
+		if err := rows.Scan(&w.url, &w.interval, &w.lastSeen, &w.callbackURL, &w.secret); err == nil {
+			watches = append(watches, w)
+		}
+	}
+	rows.Close()
+
+	sem := make(chan struct{}, watchConcurrency)
+	var wg sync.WaitGroup
+	for _, w := range watches {
+		// Claim the run up front so a slow one isn't started twice
+		if _, err := historyDB.Exec("UPDATE watches SET next_run = ? WHERE url = ?", now+w.interval, w.url); err != nil {
+			logrus.Error("Failed to schedule watch: ", err)
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(w dueWatch) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			runWatch(w)
+		}(w)
+	}
+	wg.Wait()
+}
+
+// dueWatch is a watch picked up by runDueWatches.
+type dueWatch struct {
+	url         string
+	interval    int64
+	lastSeen    *string
+	callbackURL string
+	secret      string
+}
+
+// runWatch re-extracts one watch and notifies its webhook if the title,
+// description or image differ from what the previous run saw.
+func runWatch(w dueWatch) {
+	logrus.Debug("Re-extracting watched ", w.url)
+	result, err := forceRefresh(context.Background(), w.url, extractOptions{})
+	if err != nil {
+		logrus.Warn("Failed to re-extract watched ", w.url, ": ", err)
+		if _, err := historyDB.Exec("UPDATE watches SET last_run = ?, last_error = ? WHERE url = ?", time.Now().UnixMilli(), err.Error(), w.url); err != nil {
+			logrus.Error("Failed to update watch: ", err)
+		}
+		return
+	}
+
+	seen := watchedFieldsOf(result)
+	if w.lastSeen != nil && w.callbackURL != "" {
+		var previous watchedFields
+		if err := json.Unmarshal([]byte(*w.lastSeen), &previous); err == nil {
+			if changes := previous.changes(seen); len(changes) > 0 {
+				go notifyWatch(w, result, changes)
+			}
+		}
+	}
+	encoded, _ := json.Marshal(seen)
+	if _, err := historyDB.Exec("UPDATE watches SET last_run = ?, last_error = NULL, last_seen = ? WHERE url = ?", time.Now().UnixMilli(), string(encoded), w.url); err != nil {
+		logrus.Error("Failed to update watch: ", err)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	URL "net/url"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const webhookUserAgent = "link2json-webhook/1.0"
+
+var (
+	webhookClient  *http.Client
+	webhookRetries int           // Further attempts after the first failed delivery
+	webhookBackoff time.Duration // Wait before the first retry, doubled for each one after
+
+	// Whether callbacks may reach loopback, private and link-local addresses,
+	// off so a callback can't be pointed at the network the server runs in
+	allowPrivateCallbacks bool
+)
+
+var errPrivateCallback = errors.New("callback resolves to a private address")
+
+const deliverySchema = `
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	delivery_id  TEXT    NOT NULL, -- Shared by every attempt at the same notification
+	subject      TEXT    NOT NULL, -- Watched URL or job ID the notification is about
+	event        TEXT    NOT NULL,
+	callback_url TEXT    NOT NULL,
+	attempt      INTEGER NOT NULL,
+	status       INTEGER,          -- Null when no response came back
+	error        TEXT,
+	duration_ms  INTEGER NOT NULL,
+	created_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subject ON webhook_deliveries (subject, created_at);
+`
+
+// watchedFields are the parts of a link preview a watch notifies about.
+type watchedFields struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+}
+
+// delivery is one attempt at calling a webhook, as listed by GET /watches/deliveries.
+type delivery struct {
+	DeliveryID  string    `json:"delivery_id"`
+	Event       string    `json:"event"`
+	CallbackURL string    `json:"callback_url"`
+	Attempt     int       `json:"attempt"`
+	Status      *int      `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Duration    int       `json:"duration"` // Milliseconds
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// loadWebhookConfig reads the webhook delivery settings from the environment.
+func loadWebhookConfig() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Checked again on every connection, as the name may resolve differently
+	// by the time a delivery is made and redirects lead elsewhere
+	transport.DialContext = (&net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !callbackIPAllowed(ip) {
+				return errPrivateCallback
+			}
+			return nil
+		},
+	}).DialContext
+	webhookClient = &http.Client{Transport: transport, Timeout: envDuration("LINK2JSON_WEBHOOK_TIMEOUT", 10*time.Second)}
+	webhookRetries = envInt("LINK2JSON_WEBHOOK_RETRIES", 5)
+	webhookBackoff = envDuration("LINK2JSON_WEBHOOK_BACKOFF", 2*time.Second)
+	allowPrivateCallbacks = envBool("LINK2JSON_ALLOW_PRIVATE_CALLBACKS", false)
+}
+
+// validateCallbackURL checks that raw is an http(s) URL whose host resolves
+// only to addresses callbacks may be delivered to.
+func validateCallbackURL(ctx context.Context, raw string) error {
+	u, err := URL.ParseRequestURI(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("callback must be an http or https URL")
+	}
+	if u.Hostname() == "" {
+		return errors.New("callback has no host")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !callbackIPAllowed(addr.IP) {
+			return errPrivateCallback
+		}
+	}
+	return nil
+}
+
+// callbackIPAllowed reports whether a callback may connect to ip.
+func callbackIPAllowed(ip net.IP) bool {
+	if allowPrivateCallbacks {
+		return true
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsUnspecified()
+}
+
+func watchedFieldsOf(result *extraction) watchedFields {
+	fields := watchedFields{Title: result.Metadata.Title, Description: result.Metadata.Description}
+	if len(result.Metadata.Images) > 0 {
+		fields.Image = result.Metadata.Images[0].URL
+	}
+	return fields
+}
+
+// changes lists the fields that differ in updated.
+func (f watchedFields) changes(updated watchedFields) []fieldChange {
+	var changes []fieldChange
+	if f.Title != updated.Title {
+		changes = append(changes, fieldChange{Field: "title", Before: f.Title, After: updated.Title})
+	}
+	if f.Description != updated.Description {
+		changes = append(changes, fieldChange{Field: "description", Before: f.Description, After: updated.Description})
+	}
+	if f.Image != updated.Image {
+		changes = append(changes, fieldChange{Field: "image", Before: f.Image, After: updated.Image})
+	}
+	return changes
+}
+
+// notifyWatch POSTs a change notification to the watch's callback.
+func notifyWatch(w dueWatch, result *extraction, changes []fieldChange) {
+	body, err := json.Marshal(gin.H{
+		"event":       "watch.changed",
+		"url":         w.url,
+		"changes":     changes,
+		"metadata":    newExtractResponse(w.url, result),
+		"detected_at": time.Now().UTC(),
+	})
+	if err != nil {
+		logrus.Error("Failed to encode webhook payload for ", w.url, ": ", err)
+		return
+	}
+	deliverWebhook("watch.changed", w.url, w.callbackURL, w.secret, body)
+}
+
+// deliverWebhook POSTs an event about subject to callback, retrying with exponential backoff on
+// network errors, 429s and 5xx responses. With history on, every attempt is
+// logged to the webhook_deliveries table. With a secret, the X-Link2JSON-Signature header
+// carries the hex HMAC-SHA256 of the body.
+func deliverWebhook(event, subject, callback, secret string, body []byte) {
+	deliveryID := newID()
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookRetries+1; attempt++ {
+		status, wait, err := postWebhook(event, subject, callback, secret, deliveryID, attempt, body)
+		if err == nil {
+			logrus.Info("Delivered webhook ", deliveryID, " for ", subject, " to ", callback)
+			return
+		}
+		if status != 0 && status != http.StatusTooManyRequests && status < http.StatusInternalServerError {
+			logrus.Warn("Webhook ", deliveryID, " rejected by ", callback, ", giving up: ", err)
+			return
+		}
+		if attempt > webhookRetries {
+			break
+		}
+		if wait < backoff {
+			wait = backoff
+		}
+		logrus.Debug("Webhook ", deliveryID, " failed, retrying in ", wait, ": ", err)
+		time.Sleep(wait)
+		backoff *= 2
+	}
+	logrus.Warn("Giving up on webhook ", deliveryID, " for ", subject, " after ", webhookRetries+1, " attempts")
+}
+
+// postWebhook makes one delivery attempt and logs it. It returns the response
+// status, if any, and how long the receiver asked us to wait before retrying.
+func postWebhook(event, subject, callback, secret, deliveryID string, attempt int, body []byte) (int, time.Duration, error) {
+	start := time.Now()
+	var status int
+	var wait time.Duration
+	err := func() error {
+		req, err := http.NewRequest(http.MethodPost, callback, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", webhookUserAgent)
+		req.Header.Set("X-Link2JSON-Event", event)
+		req.Header.Set("X-Link2JSON-Delivery", deliveryID)
+		if secret != "" {
+			req.Header.Set("X-Link2JSON-Signature", "sha256="+signPayload(secret, body))
+		}
+
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status >= http.StatusMultipleChoices {
+			wait = retryAfter(resp.Header.Get("Retry-After"))
+			return errors.New("callback returned " + resp.Status)
+		}
+		return nil
+	}()
+
+	var statusCol *int
+	if status != 0 {
+		statusCol = &status
+	}
+	var errorCol *string
+	if err != nil {
+		msg := err.Error()
+		errorCol = &msg
+	}
+	if historyDB != nil {
+		_, dbErr := historyDB.Exec(
+			`INSERT INTO webhook_deliveries (delivery_id, subject, event, callback_url, attempt, status, error, duration_ms, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			deliveryID, subject, event, callback, attempt, statusCol, errorCol, time.Since(start).Milliseconds(), start.UnixMilli())
+		if dbErr != nil {
+			logrus.Error("Failed to log webhook delivery: ", dbErr)
+		}
+	}
+	return status, min(wait, maxHostDelay), err
+}
+
+// signPayload returns the hex HMAC-SHA256 of body keyed with secret.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newID returns a random 128-bit hex identifier.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// deliveriesHandler lists the most recent webhook delivery attempts for a watch.
+func deliveriesHandler(c *gin.Context) {
+	if historyDB == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "History is disabled"})
+		return
+	}
+	url, ok := queryURL(c)
+	if !ok {
+		return
+	}
+	rows, err := historyDB.QueryContext(c.Request.Context(),
+		`SELECT delivery_id, event, callback_url, attempt, status, error, duration_ms, created_at
+		FROM webhook_deliveries WHERE subject = ? ORDER BY created_at DESC, id DESC LIMIT ?`,
+		url, defaultHistoryLimit)
+	if err != nil {
+		logrus.Error("Failed to query webhook deliveries: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query deliveries"})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []delivery{}
+	for rows.Next() {
+		var d delivery
+		var errMsg *string
+		var created int64
+		if err := rows.Scan(&d.DeliveryID, &d.Event, &d.CallbackURL, &d.Attempt, &d.Status, &errMsg, &d.Duration, &created); err != nil {
+			logrus.Error("Failed to read webhook deliveries: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query deliveries"})
+			return
+		}
+		if errMsg != nil {
+			d.Error = *errMsg
+		}
+		d.CreatedAt = time.UnixMilli(created).UTC()
+		deliveries = append(deliveries, d)
+	}
+	c.JSON(http.StatusOK, gin.H{"url": url, "deliveries": deliveries})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchWebhook(t *testing.T) {
+	setupExtraction(t)
+	useHistory(t, filepath.Join(t.TempDir(), "history.db"))
+	loadWebhookConfig()
+	allowPrivateCallbacks = true // The receiver listens on loopback
+	webhookRetries, webhookBackoff = 2, 10*time.Millisecond
+	defer func() { allowPrivateCallbacks = false }()
+
+	var title atomic.Value
+	title.Store("Before")
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `<html><head><title>%s</title></head><body><p>Long enough paragraph of text, with commas, to count.</p></body></html>`, title.Load())
+	}))
+	defer page.Close()
+
+	type call struct {
+		header http.Header
+		body   []byte
+	}
+	calls := make(chan call, 10)
+	var attempts atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- call{r.Header, body}
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	w := dueWatch{url: page.URL + "/page", interval: time.Hour.Milliseconds(), callbackURL: receiver.URL, secret: "s3cret"}
+	if _, err := historyDB.Exec("INSERT INTO watches (url, interval_ms, created_at, next_run, callback_url, secret) VALUES (?, ?, 0, 0, ?, ?)",
+		w.url, w.interval, w.callbackURL, w.secret); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first run has nothing to compare with, the second sees the new title
+	runWatch(w)
+	title.Store("After")
+	var lastSeen string
+	if err := historyDB.QueryRow("SELECT last_seen FROM watches WHERE url = ?", w.url).Scan(&lastSeen); err != nil {
+		t.Fatal(err)
+	}
+	w.lastSeen = &lastSeen
+	runWatch(w)
+
+	var got []call
+	for len(got) < 2 {
+		select {
+		case c := <-calls:
+			got = append(got, c)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d webhook calls, want a failed one and its retry", len(got))
+		}
+	}
+
+	retry := got[1]
+	if want := "sha256=" + signPayload(w.secret, retry.body); retry.header.Get("X-Link2JSON-Signature") != want {
+		t.Errorf("signature %q, want %q", retry.header.Get("X-Link2JSON-Signature"), want)
+	}
+	if retry.header.Get("X-Link2JSON-Delivery") != got[0].header.Get("X-Link2JSON-Delivery") {
+		t.Error("retry carries a different delivery ID")
+	}
+	var payload struct {
+		Event   string        `json:"event"`
+		Changes []fieldChange `json:"changes"`
+	}
+	if err := json.Unmarshal(retry.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "watch.changed" || len(payload.Changes) != 1 || payload.Changes[0].Field != "title" {
+		t.Errorf("payload %s", retry.body)
+	}
+
+	// Deliveries are logged once the attempt returns
+	deadline := time.Now().Add(5 * time.Second)
+	var logged int
+	for time.Now().Before(deadline) {
+		historyDB.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE subject = ?", w.url).Scan(&logged)
+		if logged == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if logged != 2 {
+		t.Errorf("logged %d delivery attempts, want 2", logged)
+	}
+}
+
+func TestWatchedFieldsChanges(t *testing.T) {
+	before := watchedFields{Title: "A", Description: "Same", Image: "https://example.com/1.png"}
+	after := watchedFields{Title: "B", Description: "Same", Image: "https://example.com/2.png"}
+	changes := before.changes(after)
+	if len(changes) != 2 || changes[0].Field != "title" || changes[1].Field != "image" {
+		t.Errorf("changes = %+v", changes)
+	}
+	if changes := before.changes(before); len(changes) != 0 {
+		t.Errorf("unchanged fields reported %+v", changes)
+	}
+}
+
+func TestCallbackURLRejectsPrivateAddresses(t *testing.T) {
+	for _, callback := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://0.0.0.0/hook",
+		"ftp://93.184.215.14/hook",
+	} {
+		if err := validateCallbackURL(context.Background(), callback); err == nil {
+			t.Errorf("validateCallbackURL(%q) accepted it", callback)
+		}
+	}
+	if err := validateCallbackURL(context.Background(), "https://93.184.215.14/hook"); err != nil {
+		t.Errorf("public callback rejected: %v", err)
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	loadWebhookConfig()
+	resp, err := webhookClient.Post(receiver.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("delivered to a loopback receiver")
+	}
+}
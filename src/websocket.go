@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	wsMaxMessage   = 64 << 10
+	wsMaxInFlight  = 16 // Extractions one connection may have running
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsWriteTimeout = 10 * time.Second
+)
+
+var (
+	wsRate  rate.Limit // Extraction requests per second allowed on one connection
+	wsBurst int
+
+	upgrader = websocket.Upgrader{
+		// CORS already allows every origin, the socket follows suit
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+)
+
+// wsRequest is a message from the client. Type is "extract" or "cancel", and
+// ID correlates it with the responses. Sending an extract with an ID that is
+// still running cancels the earlier one, so a client unfurling as the user
+// types can keep reusing one ID per input field.
+type wsRequest struct {
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	UA       string `json:"ua"`
+	Lang     string `json:"lang"`
+	Hreflang bool   `json:"hreflang"`
+}
+
+// wsResponse is a message to the client: a "result" or an "error".
+type wsResponse struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	batchResult
+}
+
+// wsConn is one client connection and the extractions it has in flight.
+type wsConn struct {
+	conn    *websocket.Conn
+	limiter *rate.Limiter
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	pending map[string]*wsPending
+}
+
+// wsPending is a running extraction, kept so a newer request can cancel it.
+type wsPending struct {
+	cancel context.CancelFunc
+}
+
+// loadWebSocketConfig reads the per-connection limits from the environment.
+func loadWebSocketConfig() {
+	wsRate = rate.Limit(envInt("LINK2JSON_WS_RATE", 5))
+	wsBurst = envInt("LINK2JSON_WS_BURST", 10)
+}
+
+// extractSocketHandler upgrades to a WebSocket over which clients send
+// extraction requests and receive results as they finish, in any order.
+func extractSocketHandler(c *gin.Context) {
+	if !rateLimiter.Allow() {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		return
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logrus.Debug("WebSocket upgrade failed: ", err)
+		return
+	}
+
+	ws := &wsConn{
+		conn:    conn,
+		limiter: rate.NewLimiter(wsRate, wsBurst),
+		pending: map[string]*wsPending{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // Stops whatever is still running once the client goes away
+	defer conn.Close()
+
+	go ws.ping(ctx)
+	ws.read(ctx)
+}
+
+// read handles client messages until the connection closes.
+func (ws *wsConn) read(ctx context.Context) {
+	ws.conn.SetReadLimit(wsMaxMessage)
+	ws.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	ws.conn.SetPongHandler(func(string) error {
+		return ws.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		_, data, err := ws.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logrus.Debug("WebSocket closed: ", err)
+			}
+			return
+		}
+		ws.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			ws.fail("", http.StatusBadRequest, "Invalid JSON message")
+			continue
+		}
+
+		switch req.Type {
+		case "extract", "":
+			ws.extract(ctx, req)
+		case "cancel":
+			ws.cancel(req.ID)
+		default:
+			ws.fail(req.ID, http.StatusBadRequest, "Unknown message type: "+req.Type)
+		}
+	}
+}
+
+// extract starts an extraction for req, superseding any with the same ID.
+func (ws *wsConn) extract(ctx context.Context, req wsRequest) {
+	if req.ID == "" {
+		ws.fail("", http.StatusBadRequest, "Request ID is required")
+		return
+	}
+	if req.URL == "" {
+		ws.fail(req.ID, http.StatusBadRequest, "URL parameter is required")
+		return
+	}
+	// The connection's own limit keeps one client fair, the global one keeps
+	// many connections from adding up to more than the service allows
+	if !ws.limiter.Allow() || !rateLimiter.Allow() {
+		ws.fail(req.ID, http.StatusTooManyRequests, "Too many requests")
+		return
+	}
+	opts := extractOptions{AcceptLanguage: req.Lang, FollowHreflang: req.Hreflang}
+	if req.UA != "" {
+		if _, ok := lookupProfile(req.UA); !ok {
+			ws.fail(req.ID, http.StatusBadRequest, "Unknown user agent profile: "+req.UA)
+			return
+		}
+		opts.Profile = strings.ToLower(req.UA)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &wsPending{cancel: cancel}
+	ws.mu.Lock()
+	if previous, ok := ws.pending[req.ID]; ok {
+		previous.cancel()
+	} else if len(ws.pending) >= wsMaxInFlight {
+		ws.mu.Unlock()
+		cancel()
+		ws.fail(req.ID, http.StatusTooManyRequests, "Too many requests in flight")
+		return
+	}
+	ws.pending[req.ID] = p
+	ws.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		result := extractBatchURL(ctx, req.URL, opts)
+
+		ws.mu.Lock()
+		current := ws.pending[req.ID] == p
+		if current {
+			delete(ws.pending, req.ID)
+		}
+		ws.mu.Unlock()
+		// A superseded or cancelled request gets no answer
+		if !current || ctx.Err() != nil {
+			return
+		}
+
+		msg := wsResponse{Type: "result", ID: req.ID, batchResult: result}
+		if result.Status != http.StatusOK {
+			msg.Type = "error"
+		}
+		ws.write(msg)
+	}()
+}
+
+// cancel stops the extraction running under id, if any.
+func (ws *wsConn) cancel(id string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if p, ok := ws.pending[id]; ok {
+		p.cancel()
+		delete(ws.pending, id)
+	}
+}
+
+func (ws *wsConn) fail(id string, status int, message string) {
+	ws.write(wsResponse{Type: "error", ID: id, batchResult: batchResult{Status: status, Error: message}})
+}
+
+func (ws *wsConn) write(msg any) {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := ws.conn.WriteJSON(msg); err != nil {
+		logrus.Debug("WebSocket write failed: ", err)
+	}
+}
+
+// ping keeps the connection alive through proxies and detects dead peers.
+func (ws *wsConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ws.writeMu.Lock()
+			err := ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			ws.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
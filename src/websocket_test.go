@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+func TestSocketChargesGlobalLimit(t *testing.T) {
+	setupExtraction(t)
+	loadWebSocketConfig()
+	defer func(l *rate.Limiter) { rateLimiter = l }(rateLimiter)
+	// One token for the upgrade and one for a single extraction
+	rateLimiter = rate.NewLimiter(rate.Every(time.Hour), 2)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Socket</title></head></html>`)
+	}))
+	defer upstream.Close()
+
+	router := gin.New()
+	router.GET("/extract/ws", extractSocketHandler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/extract/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, id := range []string{"first", "second"} {
+		if err := conn.WriteJSON(wsRequest{Type: "extract", ID: id, URL: upstream.URL + "/" + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := map[string]int{}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(got) < 2 {
+		var msg struct {
+			ID     string `json:"id"`
+			Status int    `json:"status"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		got[msg.ID] = msg.Status
+	}
+	if got["first"] != http.StatusOK || got["second"] != http.StatusTooManyRequests {
+		t.Errorf("statuses %v, want the second extraction over the global limit", got)
+	}
+}